@@ -0,0 +1,87 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrArkoseTokenRequired is returned when the backend demands an Arkose token and no ArkoseProvider is configured.
+var ErrArkoseTokenRequired = errors.New("the backend requires an arkose token for this model, set Config.ArkoseProvider")
+
+// ArkoseProvider provides the Arkose tokens required by the conversation backend for gpt-4 family models.
+type ArkoseProvider interface {
+	// GetToken returns a fresh Arkose token.
+	GetToken(ctx context.Context) (string, error)
+}
+
+// ArkoseSolver is the default ArkoseProvider, fetching tokens from a solver endpoint.
+// The endpoint is expected to answer GET requests with a JSON object like {"token": "..."}.
+type ArkoseSolver struct {
+	// The URL of the solver endpoint.
+	Endpoint string
+	// The HTTP client used for requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// NewArkoseSolver creates a new ArkoseSolver fetching tokens from the given endpoint.
+func NewArkoseSolver(endpoint string) *ArkoseSolver {
+	return &ArkoseSolver{Endpoint: endpoint}
+}
+
+// GetToken fetches a fresh Arkose token from the solver endpoint.
+func (s *ArkoseSolver) GetToken(ctx context.Context) (string, error) {
+	httpx := s.HTTPClient
+	if httpx == nil {
+		httpx = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.Endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("system error: %w", err)
+	}
+	resp, err := httpx.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("arkose solver %s is unreachable: %w", s.Endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status from arkose solver %s: %s", s.Endpoint, resp.Status)
+	}
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid response from arkose solver %s: %w", s.Endpoint, err)
+	}
+	if result.Token == "" {
+		return "", fmt.Errorf("arkose solver %s returned an empty token", s.Endpoint)
+	}
+	return result.Token, nil
+}
+
+// setArkoseToken adds an Arkose token to the conversation payload if its model is a gpt-4 family model.
+func (c *Client) setArkoseToken(ctx context.Context, data map[string]interface{}) error {
+	model, _ := data["model"].(string)
+	if c.arkose == nil || !strings.HasPrefix(model, "gpt-4") {
+		return nil
+	}
+	token, err := c.arkose.GetToken(ctx)
+	if err != nil {
+		return err
+	}
+	data["arkose_token"] = token
+	return nil
+}
+
+// checkArkoseError returns ErrArkoseTokenRequired if the backend rejected a request for a missing Arkose token.
+func (c *Client) checkArkoseError(statusCode int, body string) error {
+	if c.arkose == nil && statusCode == http.StatusForbidden && strings.Contains(strings.ToLower(body), "arkose") {
+		return ErrArkoseTokenRequired
+	}
+	return nil
+}
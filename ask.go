@@ -5,16 +5,34 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // The OpenAI API endpoint for chat completions.
-const OPENAI_HOST = "https://api.openai.com/v1/chat/completions"
+// It is a variable so that tests can point it at a local mock server.
+var OPENAI_HOST = "https://api.openai.com/v1/chat/completions"
+
+// The default backend conversation endpoint used in access token mode, see Config.BaseURL.
+// Changing it only affects clients created afterwards.
+var DEFAULT_BASE_URL = "https://chat-api.ztorr.me/api/conversation"
+
+// The search API used by AskInternet.
+var SEARCH_URL = "https://ddg-api.herokuapp.com/search"
+
+// The default User-Agent sent with access token and auth requests.
+const DEFAULT_USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36"
+
+// ErrEmptyPrompt is returned when asking with an empty or blank prompt, unless Config.AllowEmptyPrompt is set.
+var ErrEmptyPrompt = errors.New("the prompt is empty")
 
 // The default "system" message when starting a new conversation.
 const DEFAULT_INIT_MESSAGE = "You are chatGPT, trained on a very huge dataset of conversations. Act conversationally"
@@ -22,8 +40,39 @@ const DEFAULT_INIT_MESSAGE = "You are chatGPT, trained on a very huge dataset of
 type AskOpts struct {
 	// The conversation ID to use for this request. If not specified, a new conversation ID will be generated.
 	ConversationID string
-	// The parent ID to use for this request. If not specified, a new parent ID will be generated.
+	// The parent ID to use for this request. If not specified, the last known message of the conversation is used, or a new parent ID is generated.
 	ParentID string
+	// Additional headers to send with this request, overriding the client's extra headers.
+	Headers map[string]string
+	// Additional cookies to send with this request.
+	Cookies []*http.Cookie
+	// The number of completions to generate, overriding the client's setting (API key mode only).
+	N int
+	// The biases of token IDs, merged over the client's logit bias (API key mode only).
+	LogitBias map[string]float64
+	// Up to 4 sequences where the generation stops, overriding the client's stop sequences.
+	Stop []string
+	// Whether or not to bypass the response cache for this request.
+	NoCache bool
+	// Additional parameters merged into the request payload over the client's extra parameters (API key mode only).
+	ExtraParams map[string]interface{}
+	// Whether or not the extra parameters may override the fields set by the client, like model or temperature.
+	OverrideParams bool
+	// Whether or not ConversationID must be an existing conversation, instead of starting a new one (API key mode only).
+	RequireExisting bool
+	// The seed of the sampling, for mostly deterministic responses to repeated requests (API key mode only).
+	Seed *int
+	// The name of the user sending the prompt, to tell apart several users sharing a conversation (API key mode only).
+	UserName string
+	// Whether or not to skip summarizing the conversation for this request, see Config.CompressionStrategy.
+	NoCompression bool
+	// The strict JSON Schema the response must conform to, see SchemaFor and AskJSON (API key mode only).
+	ResponseSchema json.RawMessage
+	// The name of ResponseSchema, defaults to DEFAULT_SCHEMA_NAME.
+	SchemaName string
+	// Whether or not to have the model first finish the previous answer of the conversation if a failed stream cut it off,
+	// see ChatResponse.Partial (access token mode only).
+	ResumePartial bool
 }
 
 // Choice represents a possible response and its finish reason from OpenAI's API.
@@ -42,8 +91,19 @@ type OpenAIResponse struct {
 		PromptTokens     int `json:"prompt_tokens"`
 		CompletionTokens int `json:"completion_tokens"`
 		TotalTokens      int `json:"total_tokens"`
+
+		// The breakdown of the completion tokens, only returned by some models.
+		CompletionTokensDetails struct {
+			ReasoningTokens int `json:"reasoning_tokens"` // The hidden tokens used by reasoning models to think, billed as completion tokens.
+		} `json:"completion_tokens_details"`
 	} `json:"usage"`
 	Choices []Choice `json:"choices"`
+	Cached  bool     `json:"-"` // Whether or not the response was served from the response cache.
+
+	// The fingerprint of the backend configuration serving the model, it changes when the model is updated under the same name.
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+	// The ID OpenAI assigned to the request, from the x-request-id header, to be given to OpenAI support.
+	RequestID string `json:"-"`
 }
 
 // GetResponse returns the response message from the OpenAI API response.
@@ -58,132 +118,798 @@ func (r *OpenAIResponse) GetResponse() string {
 	return r.Choices[0].Message.Content
 }
 
+// redactChoices applies Config.RedactResponse to the messages of all choices of a response.
+func (c *Client) redactChoices(response *OpenAIResponse) {
+	if c.redactResp == nil {
+		return
+	}
+	for i := range response.Choices {
+		response.Choices[i].Message.Content = c.redactResponse(response.Choices[i].Message.Content)
+	}
+}
+
+// GetRefusal returns the refusal of the first choice, set instead of the response when the model refuses a response schema.
+func (r *OpenAIResponse) GetRefusal() string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	return r.Choices[0].Message.Refusal
+}
+
+// GetResponses returns the messages of all choices from the OpenAI API response.
+func (r *OpenAIResponse) GetResponses() []string {
+	responses := make([]string, 0, len(r.Choices))
+	for _, choice := range r.Choices {
+		responses = append(responses, choice.Message.Content)
+	}
+	return responses
+}
+
 // OpenAIError represents an error returned by OpenAI's API.
 type OpenAIError struct {
 	ErrorData struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-		Param   string `json:"param"`
-		Code    int    `json:"code"`
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   string      `json:"param"`
+		Code    interface{} `json:"code"` // A string such as "invalid_api_key", or null.
 	} `json:"error"`
 }
 
 // ChatResponse represents the response returned by this client.
+//
+// In access token mode, MessageID is the ID of the assistant message itself (used by SendFeedback and Continue),
+// and ParentID is the value to pass as AskOpts.ParentID to continue the conversation from this message.
+// Both are currently the same ID, and are populated the same way in streaming and non-streaming responses.
 type ChatResponse struct {
 	Message        string `json:"message,omitempty"`
 	ConversationID string `json:"conversation_id,omitempty"`
-	ParentID       string `json:"parent_id,omitempty"`
+	ParentID       string `json:"parent_id,omitempty"`  // The parent_message_id to use for the next message in the conversation.
+	MessageID      string `json:"message_id,omitempty"` // The ID of the assistant message in access token mode.
 	Model          string `json:"model,omitempty"`
+	FinishReason   string `json:"finish_reason,omitempty"` // "max_tokens" if the response was cut off and can be continued.
+	Cached         bool   `json:"cached,omitempty"`        // Whether or not the response was served from the response cache.
+	Err            error  `json:"-"`                       // Set on the last message of a stream if the stream failed.
+
+	// The fingerprint of the backend configuration serving the model (API key mode only), see OpenAIResponse.SystemFingerprint.
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+	// The ID OpenAI assigned to the request, as asked by OpenAI support, empty for cached responses (API key mode only).
+	RequestID string `json:"request_id,omitempty"`
+	// The number of hidden tokens reasoning models like o1 used to think (API key mode only).
+	ReasoningTokens int `json:"reasoning_tokens,omitempty"`
+	// The reason the model refused to answer with a response schema, Message is then empty (API key mode only).
+	Refusal string `json:"refusal,omitempty"`
+	// Whether or not the conversation was lost by the backend and recreated, see Config.AutoRecreateConversations.
+	// ConversationID is then the ID of the new conversation (access token mode only).
+	Recreated bool `json:"recreated,omitempty"`
+	// The images and citations referenced by the messages of the response so far (access token mode only).
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// When a streamed message was received, and how long after the request was sent, e.g. to measure the time to first token.
+	// ReceivedAt is nil for the responses of Ask in API key mode, which are not streamed.
+	ReceivedAt *time.Time    `json:"received_at,omitempty"`
+	Elapsed    time.Duration `json:"elapsed,omitempty"`
+	// The ISO 639-1 code of the language the reply was pinned to with Config.ReplyLanguage, empty if none.
+	Language string `json:"language,omitempty"`
+	// Whether or not the message is only the part of the response received before its stream failed, Err being set.
+	// The partial response is recorded in the conversation, see AskOpts.ResumePartial (access token mode only).
+	Partial bool `json:"partial,omitempty"`
 }
 
 // ChatError represents a chat/auth-specific error returned by this client.
+// Use errors.As to get it from the errors returned by Ask and friends, e.g. to back off for RetryAfter.
 type ChatError struct {
-	Message string `json:"message,omitempty"`
-	Code    int    `json:"code,omitempty"`
+	Message    string        `json:"message,omitempty"`
+	Code       int           `json:"code,omitempty"`        // The HTTP status code of the response.
+	Type       string        `json:"type,omitempty"`        // The OpenAI error type, e.g. "insufficient_quota" (API key mode only).
+	Param      string        `json:"param,omitempty"`       // The request parameter the error relates to, if any (API key mode only).
+	RetryAfter time.Duration `json:"retry_after,omitempty"` // How long to wait before retrying, from the Retry-After or rate limit reset headers.
+	RequestID  string        `json:"request_id,omitempty"`  // The ID OpenAI assigned to the failed request, as asked by OpenAI support (API key mode only).
 }
 
-// Error returns the string representation of a ChatError.
+// Error returns the string representation of a ChatError, made of the available parts.
 func (e *ChatError) Error() string {
 	var message struct {
 		Detail string `json:"detail"`
 	}
-	json.Unmarshal([]byte(e.Message), &message)
-	return "chatgpt error: " + message.Detail + " (error code " + strconv.Itoa(e.Code) + ")"
+	if err := json.Unmarshal([]byte(e.Message), &message); err != nil || message.Detail == "" {
+		// The message is not a JSON detail payload, use it as is.
+		message.Detail = strings.TrimSpace(e.Message)
+	}
+	if message.Detail == "" {
+		message.Detail = http.StatusText(e.Code)
+	}
+
+	var details []string
+	if e.Type != "" {
+		details = append(details, "type "+e.Type)
+	}
+	if e.Param != "" {
+		details = append(details, "param "+e.Param)
+	}
+	if e.Code != 0 {
+		details = append(details, "error code "+strconv.Itoa(e.Code))
+	}
+	if e.RetryAfter > 0 {
+		details = append(details, "retry after "+e.RetryAfter.String())
+	}
+	if e.RequestID != "" {
+		details = append(details, "request "+e.RequestID)
+	}
+
+	text := "chatgpt error"
+	if message.Detail != "" {
+		text += ": " + message.Detail
+	}
+	if len(details) > 0 {
+		text += " (" + strings.Join(details, ", ") + ")"
+	}
+	return text
+}
+
+// retryAfter returns how long to wait before retrying a failed request, from the Retry-After header
+// (in seconds or as a date) or the x-ratelimit-reset-* headers of the OpenAI API, or zero if none is set.
+func retryAfter(header http.Header) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return time.Until(date)
+		}
+	}
+	// Wait for the later reset of the requests and tokens limits
+	var wait time.Duration
+	for _, name := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+		if reset, err := time.ParseDuration(header.Get(name)); err == nil && reset > wait {
+			wait = reset
+		}
+	}
+	return wait
 }
 
-// Ask sends a question to OpenAI API using the specified conversation ID or the default one.
+// Ask sends a question to OpenAI API using the specified conversation ID, or a new conversation if none is given.
+// The ID of the conversation is returned in ChatResponse.ConversationID, pass it back to continue the conversation.
+// With Config.DefaultConversationID, or "default" with Config.LegacyDefaultConversation, questions without a conversation ID
+// all go to that conversation instead. With Config.StatelessAsk, they are answered in a conversation that isn't kept,
+// and ChatResponse.ConversationID is empty.
 func (c *Client) Ask(ctx context.Context, prompt string, askOpts ...AskOpts) (*ChatResponse, error) { // TODO: Add support for streamChannel
-	if !c.auth.clientStarted {
+	if !c.auth.clientStarted.Load() {
 		return nil, fmt.Errorf("client is not started, call Start() first")
 	}
+	if err := c.checkPrompt(prompt); err != nil {
+		return nil, err
+	}
+	prompt = c.redactRequest(prompt)
+	c.checkAskOpts(askOpts...)
 	if c.authmode == AccessTokenMode {
 		return c.askWithAccessToken(ctx, prompt, askOpts...)
 	}
+	response, conversationId, err := c.askWithApiKey(ctx, prompt, askOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatResponse{
+		Message:        response.GetResponse(),
+		ConversationID: conversationId,
+		Model:          c.conversationEngine(conversationId),
+		Cached:         response.Cached,
+		Language:       c.replyLanguage(prompt),
+
+		SystemFingerprint: response.SystemFingerprint,
+		RequestID:         response.RequestID,
+		ReasoningTokens:   response.Usage.CompletionTokensDetails.ReasoningTokens,
+		Refusal:           response.GetRefusal(),
+	}, nil
+}
+
+// AskRaw sends a question to OpenAI API like Ask, but returns the full decoded OpenAIResponse,
+// including its ID, creation time, usage and all choices with their finish reasons.
+// It is only supported in API key mode.
+func (c *Client) AskRaw(ctx context.Context, prompt string, askOpts ...AskOpts) (*OpenAIResponse, error) {
+	if err := c.checkApiKeyMode("raw responses"); err != nil {
+		return nil, err
+	}
+	if err := c.checkPrompt(prompt); err != nil {
+		return nil, err
+	}
+	response, _, err := c.askWithApiKey(ctx, c.redactRequest(prompt), askOpts...)
+	return response, err
+}
+
+// AskN sends a question to OpenAI API and returns n independent completions, only supported in API key mode.
+// Only the first completion is added to the conversation.
+func (c *Client) AskN(ctx context.Context, prompt string, n int, askOpts ...AskOpts) ([]string, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("n must be at least 1")
+	}
+	var opts AskOpts
+	if len(askOpts) > 0 {
+		opts = askOpts[0]
+	}
+	opts.N = n
+	response, err := c.AskRaw(ctx, prompt, opts)
+	if err != nil {
+		return nil, err
+	}
+	return response.GetResponses(), nil
+}
+
+// AskBatch sends independent questions with up to concurrency requests in flight, and returns their responses
+// and errors in the order of the prompts. Each prompt gets a new conversation, unless AskOpts.ConversationID is set
+// in which case they all go to that conversation one at a time, in no particular order, each one seeing the turns of
+// the previous ones. Such a batch runs no faster than asking the prompts in a loop. Requests wait for exhausted rate limits
+// with Config.RateLimitPacing, and the remaining prompts fail with the context error once ctx is done.
+func (c *Client) AskBatch(ctx context.Context, prompts []string, concurrency int, askOpts ...AskOpts) ([]*ChatResponse, []error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var opts AskOpts
+	if len(askOpts) > 0 {
+		opts = askOpts[0]
+	}
+
+	responses := make([]*ChatResponse, len(prompts))
+	errs := make([]error, len(prompts))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, prompt := range prompts {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+		promptOpts := opts
+		if promptOpts.ConversationID == "" && c.defaultConv != "" && c.authmode == ApiKeyMode {
+			// Don't share the default conversation between the prompts
+			promptOpts.ConversationID = genUUID()
+		}
+		wg.Add(1)
+		go func(i int, prompt string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			responses[i], errs[i] = c.Ask(ctx, prompt, promptOpts)
+		}(i, prompt)
+	}
+	wg.Wait()
+	return responses, errs
+}
+
+// newConversation returns an empty conversation starting with the given system message,
+// or the client's init message if it is empty.
+func (c *Client) newConversation(systemMessage string) Conversation {
+	conversation := Conversation{}
+	initMessage := Message{
+		Role:    "system",
+		Content: DEFAULT_INIT_MESSAGE,
+	}
+	// If a custom init message is provided, use it instead of the default one.
+	if systemMessage != "" {
+		initMessage.Content = systemMessage
+	} else if c.initMessage != "" {
+		initMessage.Content = c.initMessage
+	}
+	conversation.initMessage(initMessage)
+	return conversation
+}
+
+// checkPrompt returns ErrEmptyPrompt if the prompt is blank and empty prompts are not allowed.
+func (c *Client) checkPrompt(prompt string) error {
+	if strings.TrimSpace(prompt) == "" && !c.allowEmpty {
+		return ErrEmptyPrompt
+	}
+	return nil
+}
+
+// checkAskOpts logs a warning for each option of askOpts which has no meaning in the client's auth mode,
+// so that misuse is visible instead of silently ignored.
+func (c *Client) checkAskOpts(askOpts ...AskOpts) {
+	if len(askOpts) == 0 {
+		return
+	}
+	opts := askOpts[0]
+	var ignored []string
+	if c.authmode == ApiKeyMode {
+		if opts.ParentID != "" {
+			ignored = append(ignored, "ParentID")
+		}
+	} else {
+		if opts.N > 0 {
+			ignored = append(ignored, "N")
+		}
+		if len(opts.LogitBias) > 0 {
+			ignored = append(ignored, "LogitBias")
+		}
+		if len(opts.ExtraParams) > 0 {
+			ignored = append(ignored, "ExtraParams")
+		}
+		if opts.RequireExisting {
+			ignored = append(ignored, "RequireExisting")
+		}
+		if opts.Seed != nil {
+			ignored = append(ignored, "Seed")
+		}
+		if opts.UserName != "" {
+			ignored = append(ignored, "UserName")
+		}
+		if len(opts.ResponseSchema) > 0 {
+			ignored = append(ignored, "ResponseSchema")
+		}
+	}
+	if opts.ParentID != "" && opts.ConversationID == "" {
+		c.logger.Warn("AskOpts.ParentID is set without a ConversationID")
+	}
+	for _, name := range ignored {
+		c.logger.Warnf("AskOpts.%s has no effect in this auth mode and is ignored", name)
+	}
+}
+
+// AskWithMessages sends exactly the given messages to OpenAI API, e.g. with few-shot examples or injected assistant turns,
+// without the client's conversation bookkeeping. The messages must fit in the token limit of the engine.
+// Nothing is recorded unless AskOpts.ConversationID is set, in which case the conversation with this ID
+// is replaced with the messages and the reply, so that it can be continued with Ask.
+// It is only supported in API key mode, it returns ErrRequiresAPIKey otherwise.
+func (c *Client) AskWithMessages(ctx context.Context, messages []Message, askOpts ...AskOpts) (*ChatResponse, error) {
+	if !c.auth.clientStarted.Load() {
+		return nil, fmt.Errorf("client is not started, call Start() first")
+	}
+	if c.authmode != ApiKeyMode {
+		return nil, fmt.Errorf("the access token backend doesn't accept arbitrary message histories: %w", ErrRequiresAPIKey)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no messages to send")
+	}
+	if err := validateLogitBias(c.logitBias(askOpts...)); err != nil {
+		return nil, err
+	}
+	if _, err := c.stopSequences(askOpts...); err != nil {
+		return nil, err
+	}
+
+	if c.redactReq != nil {
+		redacted := make([]Message, len(messages))
+		for i, message := range messages {
+			message.Content = c.redactRequest(message.Content)
+			redacted[i] = message
+		}
+		messages = redacted
+	}
+
+	// Use the engine pinned on the named conversation, if any, and keep the pin when the conversation is replaced
+	engine := c.GetEngine()
+	var pinned string
+	if len(askOpts) > 0 && askOpts[0].ConversationID != "" {
+		if conversation, ok := c.cachedConversation(askOpts[0].ConversationID); ok && conversation.Engine != "" {
+			engine, pinned = conversation.Engine, conversation.Engine
+		}
+	}
+	if tokens, limit := countTokens(engine, messages), getEngineTokenLimit(engine); tokens > limit {
+		return nil, fmt.Errorf("the messages have %d tokens, over the %d tokens limit of %s", tokens, limit, engine)
+	}
+
+	if len(askOpts) > 0 && askOpts[0].ConversationID != "" {
+		// Don't let a concurrent Ask add a turn to the conversation which is then replaced
+		unlock, err := c.lockConversation(ctx, askOpts[0].ConversationID)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+		var done func()
+		ctx, done = c.withConversationCancel(ctx, askOpts[0].ConversationID)
+		defer done()
+	}
+	response, err := c.askOpenAI(ctx, engine, messages, nil, askOpts...)
+	if err != nil {
+		return nil, err
+	}
+	c.redactChoices(response)
+
+	// Record the messages and the reply into the named conversation, if requested.
+	var conversationId string
+	if len(askOpts) > 0 && askOpts[0].ConversationID != "" {
+		conversationId = askOpts[0].ConversationID
+		conversation := Conversation{Engine: pinned}
+		for _, message := range messages {
+			if message.Role == "system" && conversation.InitMessage == "" {
+				conversation.InitMessage = message.Content
+			}
+			conversation.addMessage(message)
+		}
+		conversation.addMessage(Message{
+			Role:      "assistant",
+			Content:   response.GetResponse(),
+			ID:        response.ID,
+			CreatedAt: time.Now(),
+		})
+		c.mu.Lock()
+		c.conversations[conversationId] = conversation
+		c.mu.Unlock()
+		c.saveConversation(conversationId, conversation)
+		c.emitMessage(conversationId, conversation.Messages[len(conversation.Messages)-1])
+	}
+
+	return &ChatResponse{
+		Message:        response.GetResponse(),
+		ConversationID: conversationId,
+		Model:          engine,
+
+		SystemFingerprint: response.SystemFingerprint,
+		RequestID:         response.RequestID,
+		ReasoningTokens:   response.Usage.CompletionTokensDetails.ReasoningTokens,
+		Refusal:           response.GetRefusal(),
+	}, nil
+}
+
+// askWithApiKey sends a question to OpenAI API using the specified conversation ID or the default one,
+// and returns the raw response along with the conversation ID used.
+func (c *Client) askWithApiKey(ctx context.Context, prompt string, askOpts ...AskOpts) (*OpenAIResponse, string, error) {
+	if err := validateLogitBias(c.logitBias(askOpts...)); err != nil {
+		return nil, "", err
+	}
+	if _, err := c.stopSequences(askOpts...); err != nil {
+		return nil, "", err
+	}
+
 	var conversation Conversation
 	var conversationId string
 
+	requireExisting := false
+	userName := ""
 	if len(askOpts) > 0 {
+		userName = askOpts[0].UserName
 		if askOpts[0].ConversationID != "" {
 			conversationId = askOpts[0].ConversationID
+			requireExisting = c.strict || askOpts[0].RequireExisting
 		}
 	}
 
-	// Use "default" as the conversation ID if none is provided.
+	// Start a new conversation with a generated ID if none is provided, or use the default conversation if configured.
+	stateless, generated := false, false
 	if conversationId == "" {
-		conversationId = "default"
+		if c.defaultConv != "" {
+			conversationId = c.defaultConv
+		} else if conversationId = genUUID(); conversationId == "" {
+			return nil, "", fmt.Errorf("failed to generate a conversation ID")
+		}
+		generated = c.defaultConv == ""
+		stateless = c.stateless && generated
+	}
+	// Wait for the other questions of the conversation, so that each one is answered with the turns of the previous ones
+	unlock, err := c.lockConversation(ctx, conversationId)
+	if err != nil {
+		return nil, "", err
 	}
+	defer unlock()
+	if stateless {
+		// The one-off conversation is only kept in memory while the question is answered
+		defer func() {
+			c.mu.Lock()
+			delete(c.conversations, conversationId)
+			c.mu.Unlock()
+		}()
+	}
+	ctx, done := c.withConversationCancel(ctx, conversationId)
+	defer done()
+
+	// Read the conversation through from the store into memory, if it is only stored there.
+	c.cachedConversation(conversationId)
 
 	// If there's no existing conversation with the given ID, create a new one with a system message.
-	if _, ok := c.conversations[conversationId]; !ok {
-		conversation = Conversation{}
-		initMessage := Message{
-			Role:    "system",
-			Content: DEFAULT_INIT_MESSAGE,
+	c.mu.Lock()
+	_, exists := c.conversations[conversationId]
+	if !exists {
+		if requireExisting {
+			c.mu.Unlock()
+			return nil, "", fmt.Errorf("%w: %s", ErrConversationNotFound, conversationId)
 		}
-		// If a custom init message is provided, use it instead of the default one.
-		if c.initMessage != "" {
-			initMessage.Content = c.initMessage
-		}
-		conversation.initMessage(initMessage)
-		conversation.addMessage(Message{
-			Role:    "user",
-			Content: prompt,
-		}) // add current message to the conversation flow
-		c.conversations[conversationId] = conversation
-	} else { // Otherwise, retrieve the existing conversation and add the user's message to it.
+		conversation = c.newConversation("")
+	} else { // Otherwise, retrieve the existing conversation.
 		conversation = c.conversations[conversationId]
+	}
+	// Add the user's message to the conversation flow, an allowed empty prompt sends the conversation as is.
+	if strings.TrimSpace(prompt) != "" {
 		conversation.addMessage(Message{
-			Role:    "user",
-			Content: prompt,
+			Role:      "user",
+			Content:   prompt,
+			Name:      userName,
+			CreatedAt: time.Now(),
 		})
-		c.conversations[conversationId] = conversation
+	}
+	c.conversations[conversationId] = conversation
+	if !stateless {
+		c.touchGenerated(conversationId, generated)
 	}
 
-	// Check the number of tokens in the conversation and tokenize it if necessary.
-	tokens := conversation.getTokenCount()
-	if tokens > getEngineTokenLimit(c.engine) {
-		conversation.tokenizeMessage(c.engine)
-		c.conversations[conversationId] = conversation
+	// Use the engine pinned on the conversation, if any.
+	engine := c.GetEngine()
+	if conversation.Engine != "" {
+		engine = conversation.Engine
 	}
 
-	// Send the conversation messages to OpenAI API and return its response/error.
-	response, err := c.askOpenAI(ctx, conversation.Messages, nil)
-	if err == nil {
-		// If there was no error, add the response message to the conversation and update it.
-		conversation.addMessage(Message{
-			Role:    "assistant",
-			Content: response.GetResponse(),
-		})
-		c.conversations[conversationId] = conversation
+	c.mu.Unlock()
+	// One-off conversations are deleted once answered, so they aren't reported as created
+	if !exists && !stateless {
+		c.conversationCreated(conversationId, conversation)
 	}
-	return &ChatResponse{
-		Message:        response.GetResponse(),
-		ConversationID: conversationId,
-		Model:          c.engine,
-	}, err
+	if strings.TrimSpace(prompt) != "" {
+		c.emitMessage(conversationId, conversation.Messages[len(conversation.Messages)-1])
+	}
+
+	// Summarize the oldest messages if the conversation approaches the token limit, without holding the lock during the request.
+	truncated := false
+	if c.shouldSummarize(conversation, engine, askOpts...) {
+		summarized, err := c.summarizeConversation(ctx, conversation, engine)
+		if err != nil {
+			c.logger.Warn(err.Error())
+		}
+		truncated = summarized.Summaries > conversation.Summaries
+		conversation = summarized
+	}
+
+	// Truncate the conversation if its messages and the tokens reserved for the response don't fit in the token limit.
+	c.mu.Lock()
+	if conversation.tokenizeMessage(engine, c.tokenBudget(engine)) {
+		truncated = true
+	}
+	c.conversations[conversationId] = conversation
+	c.mu.Unlock()
+	if truncated {
+		c.emit(Event{Type: EventConversationTruncated, ConversationID: conversationId})
+	}
+
+	// Serve the response from the cache if possible, otherwise send the conversation messages to OpenAI API.
+	var response *OpenAIResponse
+	var cacheKey string
+	cacheable := c.cacheable(askOpts...)
+	if cacheable {
+		cacheKey = c.responseCacheKey(engine, conversation, askOpts...)
+		message, ok := c.cache.Get(cacheKey)
+		c.emitCacheLookup(CacheResponse, ok)
+		if ok {
+			c.logger.Debug("Serving response from cache")
+			response = &OpenAIResponse{
+				Model:   engine,
+				Choices: []Choice{{Message: Message{Role: "assistant", Content: message}, FinishReason: "stop"}},
+				Cached:  true,
+			}
+		}
+	}
+	if response == nil {
+		var err error
+		// The reply language instruction is only sent, it is not kept in the conversation
+		messages, _ := c.withReplyLanguage(conversation.Messages, prompt)
+		if response, err = c.askOpenAI(ctx, engine, messages, nil, askOpts...); err != nil {
+			return nil, conversationId, err
+		}
+		c.redactChoices(response)
+		if cacheable {
+			c.cache.Set(cacheKey, response.GetResponse(), c.cacheTTL)
+		}
+	}
+
+	// If there was no error, add the response message to the conversation and update it.
+	conversation.addMessage(Message{
+		Role:      "assistant",
+		Content:   response.GetResponse(),
+		Refusal:   response.GetRefusal(),
+		ID:        response.ID,
+		CreatedAt: time.Now(),
+	})
+	if stateless {
+		c.emitMessage(conversationId, conversation.Messages[len(conversation.Messages)-1])
+		return response, "", nil
+	}
+	c.mu.Lock()
+	c.conversations[conversationId] = conversation
+	c.touchGenerated(conversationId, generated)
+	c.mu.Unlock()
+	c.saveConversation(conversationId, conversation)
+	c.emitMessage(conversationId, conversation.Messages[len(conversation.Messages)-1])
+	return response, conversationId, nil
 }
 
 // AskStream sends a question to OpenAI API using the specified conversation ID or the default one and streams the response.
+// If the stream fails, its last message has Err set, with the response received so far and Partial set if there was any.
 func (c *Client) AskStream(ctx context.Context, prompt string, askOpts ...AskOpts) (chan *ChatResponse, error) {
 	// Check if the client has been started and is using access token mode
-	if !c.auth.clientStarted {
+	if !c.auth.clientStarted.Load() {
 		return nil, fmt.Errorf("client is not started, call Start() first")
 	}
+	if err := c.checkPrompt(prompt); err != nil {
+		return nil, err
+	}
+	prompt = c.redactRequest(prompt)
+	c.checkAskOpts(askOpts...)
 	if c.authmode == AccessTokenMode {
 		// Create a new channel for the response messages
-		newChannel := make(chan *ChatResponse, 60)
+		newChannel := make(chan *ChatResponse, c.streamBuffer)
 
 		// Call the askStreamWithAccessToken method to send the question and stream the response
 		return newChannel, c.askStreamWithAccessToken(ctx, prompt, newChannel, askOpts...)
 	}
 	// If the client is not using access token mode, return an error
-	return nil, fmt.Errorf("streaming is not yet implemented for API key mode")
+	return nil, fmt.Errorf("streaming: %w", ErrRequiresAccessToken)
+}
+
+// AskStreamRaw sends a question like AskStream, and forwards each raw "data:" line of the backend's event stream
+// to the returned channel before any parsing, for debugging the backend's responses. The channel is closed at the end of the stream.
+// The response is not recorded in the conversation. It is only supported in access token mode.
+func (c *Client) AskStreamRaw(ctx context.Context, prompt string, askOpts ...AskOpts) (chan string, error) {
+	if !c.auth.clientStarted.Load() {
+		return nil, fmt.Errorf("client is not started, call Start() first")
+	}
+	if err := c.checkPrompt(prompt); err != nil {
+		return nil, err
+	}
+	if c.authmode != AccessTokenMode {
+		return nil, fmt.Errorf("raw streams: %w", ErrRequiresAccessToken)
+	}
+	prompt = c.redactRequest(prompt)
+	c.checkAskOpts(askOpts...)
+
+	data := c.makeAccessTokenPayload(prompt, askOpts...)
+	body, err := c.openConversationStream(ctx, data, askOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan string, c.streamBuffer)
+	go func() {
+		defer close(ch)
+		defer body.Close()
+		scanner := bufio.NewScanner(sniffGzip(body))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			if err := sendStream(ctx, ch, c.redactResponse(line), c.slowConsumer); err != nil {
+				c.logger.Debugf("Raw stream aborted: %v", err)
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			c.logger.Debugf("Raw stream ended with an error: %v", err)
+		}
+	}()
+	return ch, nil
+}
+
+// StreamDelta is a new piece of a streamed response, passed to the callback of AskStreamCollectTimed.
+type StreamDelta struct {
+	Text       string        // The text added to the response.
+	ReceivedAt time.Time     // When the piece was received.
+	Elapsed    time.Duration // How long after the request was sent the piece was received.
+}
+
+// AskStreamCollectTimed is like AskStreamCollect, but onDelta also gets when each piece was received,
+// e.g. to measure the time to first token and the latency between tokens.
+func (c *Client) AskStreamCollectTimed(ctx context.Context, prompt string, onDelta func(StreamDelta), askOpts ...AskOpts) (*ChatResponse, error) {
+	var onMessage func(string, *ChatResponse)
+	if onDelta != nil {
+		onMessage = func(delta string, message *ChatResponse) {
+			timed := StreamDelta{Text: delta, Elapsed: message.Elapsed}
+			if message.ReceivedAt != nil {
+				timed.ReceivedAt = *message.ReceivedAt
+			}
+			onDelta(timed)
+		}
+	}
+	return c.askStreamCollect(ctx, prompt, onMessage, askOpts...)
+}
+
+// AskStreamCollect sends a question like AskStream, calling onDelta with each new piece of the response as it streams in,
+// and returns the final aggregated response once the stream completes.
+// If the stream fails midway, the response received so far is returned with the error, see ChatResponse.Partial.
+// In API key mode, which doesn't stream yet, the response is requested with Ask and passed to onDelta at once.
+func (c *Client) AskStreamCollect(ctx context.Context, prompt string, onDelta func(string), askOpts ...AskOpts) (*ChatResponse, error) {
+	var onMessage func(string, *ChatResponse)
+	if onDelta != nil {
+		onMessage = func(delta string, _ *ChatResponse) { onDelta(delta) }
+	}
+	return c.askStreamCollect(ctx, prompt, onMessage, askOpts...)
+}
+
+// askStreamCollect implements AskStreamCollect, onDelta gets each new piece with the message it is from.
+func (c *Client) askStreamCollect(ctx context.Context, prompt string, onDelta func(string, *ChatResponse), askOpts ...AskOpts) (*ChatResponse, error) {
+	if c.auth.clientStarted.Load() && c.authmode == ApiKeyMode {
+		start := time.Now()
+		response, err := c.Ask(ctx, prompt, askOpts...)
+		if err != nil {
+			return nil, err
+		}
+		receivedAt := time.Now()
+		response.ReceivedAt = &receivedAt
+		response.Elapsed = receivedAt.Sub(start)
+		if onDelta != nil && response.Message != "" {
+			onDelta(response.Message, response)
+		}
+		return response, nil
+	}
+
+	ch, err := c.AskStream(ctx, prompt, askOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return collectStream(ch, onDelta)
+}
+
+// collectStream reads the messages of a stream until it ends, calling onDelta with each new piece of the response
+// and the message it is from, and returns the last message, along with the error of a failed stream.
+func collectStream(ch chan *ChatResponse, onDelta func(string, *ChatResponse)) (*ChatResponse, error) {
+	// The streamed messages hold the whole response so far, the delta is the part after the previous message
+	var last *ChatResponse
+	for message := range ch {
+		if message.Err != nil {
+			if message.Partial {
+				return message, message.Err
+			}
+			return nil, message.Err
+		}
+		if onDelta != nil {
+			previous := ""
+			if last != nil {
+				previous = last.Message
+			}
+			// A message not extending the previous one (e.g. truncated at a stop sequence) has no delta
+			if strings.HasPrefix(message.Message, previous) && len(message.Message) > len(previous) {
+				onDelta(message.Message[len(previous):], message)
+			}
+		}
+		last = message
+	}
+	if last == nil {
+		return nil, fmt.Errorf("stream ended without a response")
+	}
+	return last, nil
+}
+
+// AskStreamReader sends a question like AskStream, and returns a reader of the response text as it streams in,
+// for use with io.Copy and the like. The reader returns io.EOF once the response is complete, or the error of the stream.
+// Closing the reader before the end cancels the stream.
+// In API key mode, which doesn't stream yet, the response is requested with Ask and read at once.
+func (c *Client) AskStreamReader(ctx context.Context, prompt string, askOpts ...AskOpts) (io.ReadCloser, error) {
+	if c.auth.clientStarted.Load() && c.authmode == ApiKeyMode {
+		response, err := c.Ask(ctx, prompt, askOpts...)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader(response.Message)), nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch, err := c.AskStream(ctx, prompt, askOpts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer cancel()
+		_, err := collectStream(ch, func(delta string, _ *ChatResponse) {
+			// Writes fail once the reader is closed, the stream is then cancelled and drained
+			writer.Write([]byte(delta))
+		})
+		writer.CloseWithError(err)
+	}()
+	return &streamReader{PipeReader: reader, cancel: cancel}, nil
+}
+
+// streamReader is the reader returned by AskStreamReader, which cancels the stream when closed.
+type streamReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *streamReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
 }
 
 // AskInternet sends a question to the specified internet engine and returns the response/error.
 func (c *Client) AskInternet(ctx context.Context, prompt string) (*ChatResponse, error) {
 	// Check if the client has been started
-	if !c.auth.clientStarted {
+	if !c.auth.clientStarted.Load() {
 		return nil, fmt.Errorf("client is not started, call Start() first")
 	}
 
@@ -231,7 +957,7 @@ func (c *Client) askInternet(ctx context.Context, actual_qn, query_fmt string) (
 	query_fmt = strings.ReplaceAll(query_fmt, "Possible search query: ", "")
 
 	// Set the URL and payload for the external search API.
-	query_url := "https://ddg-api.herokuapp.com/search"
+	query_url := SEARCH_URL
 	var query_payload struct {
 		Query string `json:"query"`
 		Limit int    `json:"limit"`
@@ -239,10 +965,14 @@ func (c *Client) askInternet(ctx context.Context, actual_qn, query_fmt string) (
 	query_payload.Query = query_fmt
 	query_payload.Limit = 3
 
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	// Marshal the query payload to JSON and create an HTTP request with the JSON payload.
 	query_json, _ := json.Marshal(query_payload)
 	req, _ := http.NewRequestWithContext(ctx, "POST", query_url, strings.NewReader(string(query_json)))
 	req.Header.Add("Content-Type", "application/json")
+	c.setCustomHeaders(req)
 
 	// Send the request and handle the response.
 	if resp, err := c.httpx.Do(req); err != nil {
@@ -259,7 +989,7 @@ func (c *Client) askInternet(ctx context.Context, actual_qn, query_fmt string) (
 			if err := json.Unmarshal(respBody, &errResp); err != nil {
 				return "", fmt.Errorf("error: %s", resp.Status)
 			}
-			return "", &ChatError{errResp.Error, resp.StatusCode}
+			return "", &ChatError{Message: errResp.Error, Code: resp.StatusCode}
 		}
 		// If the response status code is 200, parse the response body as an InternetResponse and return the snippet from the first search result.
 		respBody, _ := io.ReadAll(resp.Body)
@@ -278,34 +1008,65 @@ func (c *Client) askInternet(ctx context.Context, actual_qn, query_fmt string) (
 
 // askOpenAI makes a POST request to OpenAI's API with the given messages, and returns the response.
 // If there is an HTTP error or a non-200 status code, an error is returned instead.
-func (c *Client) askOpenAI(ctx context.Context, messages []Message, streamChannel chan string) (*OpenAIResponse, error) {
+func (c *Client) askOpenAI(ctx context.Context, engine string, messages []Message, streamChannel chan string, askOpts ...AskOpts) (*OpenAIResponse, error) {
+	// Wait for exhausted rate limits to reset, the wait doesn't count towards the request timeout
+	if err := c.checkStructuredOutputs(engine, askOpts...); err != nil {
+		return nil, err
+	}
+	if err := c.waitRateLimits(ctx); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	// Create a new request with the payload and headers set.
-	req, _ := http.NewRequestWithContext(ctx, "POST", OPENAI_HOST, strings.NewReader(c.makePayload(messages)))
+	payload := c.makePayload(engine, messages, askOpts...)
+	req, _ := http.NewRequestWithContext(ctx, "POST", OPENAI_HOST, strings.NewReader(payload))
 	c.setHeaders(req, c.auth.apiKey)
+	setRequestOpts(req, askOpts...)
+	c.logRequest(req, payload)
 
 	// Send the request and handle the response.
+	start := time.Now()
 	if resp, err := c.httpx.Do(req); err != nil {
-		return nil, err
+		c.emitRequest(engine, 0, start, 0, 0)
+		return nil, c.emitFailure(err)
 	} else {
 		defer resp.Body.Close()
+		body, err := io.ReadAll(sniffGzip(resp.Body))
+		if err != nil {
+			c.emitRequest(engine, resp.StatusCode, start, 0, 0)
+			return nil, err
+		}
+		c.logResponse(resp, string(body))
+		c.updateRateLimits(resp.Header)
 
 		if resp.StatusCode == 200 {
 			// If the response has a 200 status code, parse it as an OpenAIResponse.
 			var response OpenAIResponse
-			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			err := json.Unmarshal(body, &response)
+			c.emitRequest(engine, resp.StatusCode, start, response.Usage.PromptTokens, response.Usage.CompletionTokens)
+			if err != nil {
 				return nil, err
 			}
+			response.RequestID = resp.Header.Get("x-request-id")
 			return &response, nil
 		} else {
 			// If the response has an error status code, parse it as an OpenAIError and create a ChatError from it.
 			var response OpenAIError
-			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-				return nil, err
-			}
-			return nil, &ChatError{
-				Message: response.ErrorData.Message,
-				Code:    response.ErrorData.Code,
+			if err := json.Unmarshal(body, &response); err != nil || response.ErrorData.Message == "" {
+				// The body is not an OpenAI error, e.g. an error page of a proxy
+				response.ErrorData.Message = string(body)
 			}
+			c.emitRequest(engine, resp.StatusCode, start, 0, 0)
+			return nil, c.emitFailure(&ChatError{
+				Message:    response.ErrorData.Message,
+				Code:       resp.StatusCode,
+				Type:       response.ErrorData.Type,
+				Param:      response.ErrorData.Param,
+				RetryAfter: retryAfter(resp.Header),
+				RequestID:  resp.Header.Get("x-request-id"),
+			})
 		}
 	}
 }
@@ -323,33 +1084,313 @@ type Payload struct {
 
 	// The "top-p" parameter controls the "conservatism" of the AI's responses. Lower values will generate more predictable and "safe" responses.
 	TopP float64 `json:"top_p"`
+
+	// The number of completions to generate for the messages.
+	N int `json:"n,omitempty"`
+
+	// The biases added to the likelihood of token IDs, from -100 (ban) to 100 (exclusive selection).
+	LogitBias map[string]float64 `json:"logit_bias,omitempty"`
+
+	// Up to 4 sequences where the API will stop generating further tokens.
+	Stop []string `json:"stop,omitempty"`
+
+	// The seed of the sampling, repeated requests with the same seed and parameters should return the same result.
+	Seed *int `json:"seed,omitempty"`
+
+	// The format of the response, a JSON Schema for structured outputs.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 }
 
-// makePayload returns the JSON payload for the given messages with the client's settings.
-func (c *Client) makePayload(messages []Message) string {
+// makePayload returns the JSON payload for the given engine and messages with the client's settings,
+// overridden by the given askOpts.
+func (c *Client) makePayload(engine string, messages []Message, askOpts ...AskOpts) string {
+	messages = withoutMetadata(messages)
+	capabilities, reasoning := capabilitiesForEngine(engine)
+	if reasoning {
+		messages = remapSystemMessages(messages, capabilities.systemRole)
+	}
 	payload := Payload{
-		Model:       c.engine,
+		Model:       engine,
 		Messages:    messages,
 		Temperature: c.temperature,
 		TopP:        1.0,
+		N:           c.n,
+		LogitBias:   c.logitBias(askOpts...),
+	}
+	payload.Stop, _ = c.stopSequences(askOpts...)
+	if len(askOpts) > 0 {
+		if askOpts[0].N > 0 {
+			payload.N = askOpts[0].N
+		}
+		payload.Seed = askOpts[0].Seed
 	}
+	payload.ResponseFormat = responseFormat(askOpts...)
 	jsonified, _ := json.Marshal(payload)
-	return string(jsonified)
+	merged := c.mergeExtraParams(jsonified, askOpts...)
+	if reasoning {
+		return c.adaptReasoningPayload(engine, merged, capabilities)
+	}
+	return merged
 }
 
-// setHeaders sets the Authorization and Content-Type headers on the given request.
+// samplingParams are the payload parameters rejected by reasoning models without sampling control.
+var samplingParams = []string{"temperature", "top_p", "presence_penalty", "frequency_penalty", "logit_bias"}
+
+// adaptReasoningPayload rewrites a payload for a reasoning model: max_tokens is sent as max_completion_tokens
+// and the unsupported sampling parameters are dropped, see modelCapabilities.
+func (c *Client) adaptReasoningPayload(engine string, payload string, capabilities modelCapabilities) string {
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &params); err != nil {
+		return payload
+	}
+	if maxTokens, ok := params["max_tokens"]; ok && capabilities.maxCompletionTokens {
+		if _, ok := params["max_completion_tokens"]; !ok {
+			params["max_completion_tokens"] = maxTokens
+		}
+		delete(params, "max_tokens")
+	}
+	if capabilities.noSampling {
+		var dropped []string
+		for _, name := range samplingParams {
+			if _, ok := params[name]; ok {
+				dropped = append(dropped, name)
+				delete(params, name)
+			}
+		}
+		if len(dropped) > 0 {
+			c.logger.Debugf("Dropped %s, they are not supported by %s", strings.Join(dropped, ", "), engine)
+		}
+	}
+	jsonified, _ := json.Marshal(params)
+	return string(jsonified)
+}
+
+// remapSystemMessages returns a copy of messages with the system messages sent as role.
+// With the "user" role, system messages are merged into the next user message instead.
+func remapSystemMessages(messages []Message, role string) []Message {
+	remapped := make([]Message, 0, len(messages))
+	var pending []string
+	for _, message := range messages {
+		if message.Role == "system" {
+			if role != "user" {
+				message.Role = role
+				remapped = append(remapped, message)
+			} else {
+				pending = append(pending, message.Content)
+			}
+			continue
+		}
+		if message.Role == "user" && len(pending) > 0 {
+			message.Content = strings.Join(append(pending, message.Content), "\n\n")
+			pending = nil
+		}
+		remapped = append(remapped, message)
+	}
+	if len(pending) > 0 {
+		remapped = append(remapped, Message{Role: "user", Content: strings.Join(pending, "\n\n")})
+	}
+	return remapped
+}
+
+// mergeExtraParams merges the client's and askOpts' extra parameters into the JSON payload.
+// The fields already set in the payload are kept unless askOpts allows overriding them.
+func (c *Client) mergeExtraParams(payload []byte, askOpts ...AskOpts) string {
+	var params map[string]interface{}
+	override := false
+	if len(askOpts) > 0 {
+		params = askOpts[0].ExtraParams
+		override = askOpts[0].OverrideParams
+	}
+	if len(c.extraParams) == 0 && len(params) == 0 {
+		return string(payload)
+	}
+
+	var merged map[string]interface{}
+	if err := json.Unmarshal(payload, &merged); err != nil {
+		return string(payload)
+	}
+	for _, extra := range []map[string]interface{}{c.extraParams, params} {
+		for key, value := range extra {
+			if _, ok := merged[key]; ok && !override {
+				c.logger.Warnf("Extra parameter %s ignored, it is already set by the client", key)
+				continue
+			}
+			merged[key] = value
+		}
+	}
+	jsonified, _ := json.Marshal(merged)
+	return string(jsonified)
+}
+
+// logitBias returns the client's logit bias merged with the one from askOpts, per-request values win.
+func (c *Client) logitBias(askOpts ...AskOpts) map[string]float64 {
+	if len(askOpts) == 0 || len(askOpts[0].LogitBias) == 0 {
+		return c.bias
+	}
+	merged := make(map[string]float64, len(c.bias)+len(askOpts[0].LogitBias))
+	for token, bias := range c.bias {
+		merged[token] = bias
+	}
+	for token, bias := range askOpts[0].LogitBias {
+		merged[token] = bias
+	}
+	return merged
+}
+
+// stopSequences returns the stop sequences from askOpts or the client's ones,
+// or an error if there are more than 4 of them.
+func (c *Client) stopSequences(askOpts ...AskOpts) ([]string, error) {
+	stop := c.stop
+	if len(askOpts) > 0 && len(askOpts[0].Stop) > 0 {
+		stop = askOpts[0].Stop
+	}
+	if len(stop) > 4 {
+		return nil, fmt.Errorf("at most 4 stop sequences are allowed, got %d", len(stop))
+	}
+	return stop, nil
+}
+
+// truncateAtStop truncates the message at the first stop sequence it contains, and reports whether it did.
+func truncateAtStop(message string, stop []string) (string, bool) {
+	index := -1
+	for _, sequence := range stop {
+		if i := strings.Index(message, sequence); sequence != "" && i >= 0 && (index < 0 || i < index) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return message, false
+	}
+	return message[:index], true
+}
+
+// validateLogitBias checks that all biases are within [-100, 100].
+func validateLogitBias(logitBias map[string]float64) error {
+	for token, bias := range logitBias {
+		if bias < -100 || bias > 100 {
+			return fmt.Errorf("logit bias for token %s must be between -100 and 100, got %v", token, bias)
+		}
+	}
+	return nil
+}
+
+// setHeaders sets the Authorization and Content-Type headers on the given request,
+// along with the User-Agent header if one is configured, followed by the headers of Config.Headers.
 func (c *Client) setHeaders(req *http.Request, key string) {
 	req.Header.Set("Authorization", "Bearer "+key)
 	req.Header.Set("Content-Type", "application/json")
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// setCustomHeaders sets the headers of Config.Headers on a request sent without setHeaders, like file downloads.
+func (c *Client) setCustomHeaders(req *http.Request) {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// setBrowserHeaders sets the headers of a regular browser session on the given request, followed by the headers of
+// Config.Headers and the extra headers.
+// It is used for access token and auth requests only, never for api.openai.com.
+func (c *Client) setBrowserHeaders(req *http.Request) {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
+	userAgent := c.userAgent
+	if userAgent == "" {
+		userAgent = DEFAULT_USER_AGENT
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "text/event-stream, application/json, text/html, */*")
+	}
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Origin", "https://chat.openai.com")
+	req.Header.Set("Referer", "https://chat.openai.com/")
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range c.extraHeaders {
+		req.Header.Set(key, value)
+	}
+}
+
+// withTimeout derives a context bounded by the configured request timeout, if any.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.requestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.requestTimeout)
+}
+
+// logRequest logs the method, URL, headers and payload of an outgoing request at debug level.
+// The Authorization header and the credentials of the client are redacted.
+func (c *Client) logRequest(req *http.Request, payload string) {
+	if c.logger.Level > LogLevelDebug {
+		return
+	}
+	headers := make([]string, 0, len(req.Header))
+	for name, values := range req.Header {
+		value := strings.Join(values, ", ")
+		if name == "Authorization" {
+			value = "[REDACTED]"
+		}
+		headers = append(headers, name+": "+value)
+	}
+	sort.Strings(headers)
+	c.logger.Debug(c.redactSecrets(fmt.Sprintf("request: %s %s\nheaders: %s\npayload: %s", req.Method, req.URL, strings.Join(headers, "; "), payload)))
+}
+
+// logResponse logs the status and body of a response at debug level, body is empty for streamed responses.
+func (c *Client) logResponse(resp *http.Response, body string) {
+	if c.logger.Level > LogLevelDebug {
+		return
+	}
+	msg := "response: " + resp.Status
+	if resp.Request != nil {
+		msg += " " + resp.Request.URL.String()
+	}
+	if body != "" {
+		msg += "\nbody: " + c.redactResponse(body)
+	}
+	c.logger.Debug(c.redactSecrets(msg))
+}
+
+// setRequestOpts sets the per-request headers and cookies from askOpts on the given request.
+// Headers set here take precedence over the client-level ones.
+func setRequestOpts(req *http.Request, askOpts ...AskOpts) {
+	if len(askOpts) == 0 {
+		return
+	}
+	for key, value := range askOpts[0].Headers {
+		req.Header.Set(key, value)
+	}
+	for _, cookie := range askOpts[0].Cookies {
+		req.AddCookie(cookie)
+	}
 }
 
 // getEngineTokenLimit returns the maximum number of tokens that can be sent to the OpenAI API for a given engine.
 func getEngineTokenLimit(engine string) int {
 	// If the engine is "gpt-4-32k", return a limit of 32000 tokens.
-	if engine == "gpt-4-32k" {
+	if engine == EngineGPT432k {
 		return 32000
-	} else if engine == "gpt-4" { // If the engine is "gpt-4", return a limit of 8000 tokens.
+	} else if engine == EngineGPT4 { // If the engine is "gpt-4", return a limit of 8000 tokens.
 		return 8000
+	} else if engine == EngineGPT4Turbo || engine == EngineGPT4o || engine == EngineGPT4oMini { // If the engine is "gpt-4-turbo" or "gpt-4o", return a limit of 128000 tokens.
+		return 128000
+	} else if engine == EngineGPT35Turbo16k { // If the engine is "gpt-3.5-turbo-16k", return a limit of 16000 tokens.
+		return 16000
+	} else if _, ok := capabilitiesForEngine(engine); ok { // If the engine is a reasoning model, return a limit of 128000 tokens.
+		return 128000
 	} else {
 		return 4000 // default to 4000 tokens
 	}
@@ -357,44 +1398,170 @@ func getEngineTokenLimit(engine string) int {
 
 // askWithAccessToken sends a question to Custom API using the specified conversation ID or the default one.
 func (c *Client) askWithAccessToken(ctx context.Context, prompt string, askOpts ...AskOpts) (*ChatResponse, error) {
-	var conversationId string
-	var parentId string
+	// The backend doesn't support stop sequences, they are applied client side
+	stop, err := c.stopSequences(askOpts...)
+	if err != nil {
+		return nil, err
+	}
 
-	// Parse the conversation ID and parent ID from the askOpts parameter, if provided
+	// Construct the payload for the POST request
+	data := c.makeAccessTokenPayload(prompt, askOpts...)
+	ctx, done := c.withConversationCancel(ctx, payloadConversationID(data))
+	defer done()
+
+	// Send the payload and continue the response while it is cut off, if enabled
+	response, err := c.postConversation(ctx, data, askOpts...)
+	if goneID := payloadConversationID(data); goneID != "" && isConversationGone(err) {
+		if !c.autoRecreate {
+			return nil, fmt.Errorf("%w: %s: %w", ErrRemoteConversationGone, goneID, err)
+		}
+		c.logger.Infof("Conversation %s is gone on the backend, starting a new one", goneID)
+		data = c.recreatePayload(data, prompt, askOpts...)
+		if response, err = c.postConversation(ctx, data, askOpts...); err == nil {
+			response.Recreated = true
+			c.moveConversation(goneID, response.ConversationID)
+		}
+	}
+	for i := 0; err == nil && i < c.autoContinue && response.FinishReason == "max_tokens"; i++ {
+		c.logger.Debug("Response was cut off, continuing it")
+		var continuation *ChatResponse
+		if continuation, err = c.continueResponse(ctx, response.ConversationID, response.MessageID, askOpts...); err == nil {
+			continuation.Message = response.Message + continuation.Message
+			response = continuation
+		}
+	}
+	if err == nil {
+		response.Message, _ = truncateAtStop(c.redactResponse(response.Message), stop)
+		response.Language = c.replyLanguage(prompt)
+		c.recordAccessTokenTurn(data, prompt, response)
+	}
+	return response, err
+}
+
+// recreatePayload returns the payload asking prompt in a new conversation in place of the conversation of data,
+// which the backend lost, with the engine of data and the options of askOpts but the conversation and parent IDs.
+// With AskOpts.ResumePartial, a partial answer of the lost conversation is still resumed.
+func (c *Client) recreatePayload(data map[string]interface{}, prompt string, askOpts ...AskOpts) map[string]interface{} {
+	var opts AskOpts
 	if len(askOpts) > 0 {
-		if askOpts[0].ConversationID != "" {
-			conversationId = askOpts[0].ConversationID
+		opts = askOpts[0]
+	}
+	opts.ConversationID, opts.ParentID = "", ""
+	recreated := c.makeAccessTokenPayload(prompt, opts)
+	recreated["model"] = payloadEngine(data)
+	if gone, _ := c.cachedConversation(payloadConversationID(data)); opts.ResumePartial && gone.Partial {
+		messages := recreated["messages"].([]map[string]interface{})
+		messages[len(messages)-1] = makeAccessTokenMessage(resumePartialPrompt(gone.LastMessage, prompt))
+	}
+	return recreated
+}
+
+// isConversationGone returns true if err is the 404 returned by the backend for a deleted or expired conversation.
+func isConversationGone(err error) bool {
+	var chatErr *ChatError
+	return errors.As(err, &chatErr) && chatErr.Code == http.StatusNotFound
+}
+
+// moveConversation moves the local conversation lost by the backend to the ID of the conversation recreating it,
+// so that its history and engine are kept.
+func (c *Client) moveConversation(from, to string) {
+	if to == "" || to == from {
+		return
+	}
+	conversation, ok := c.cachedConversation(from)
+	if !ok {
+		return
+	}
+	conversation.Tree = nil // the nodes belong to the lost conversation
+	c.mu.Lock()
+	delete(c.conversations, from)
+	c.forgetGenerated(from)
+	if _, exists := c.conversations[to]; !exists {
+		c.conversations[to] = conversation
+	}
+	c.mu.Unlock()
+	if c.convStore != nil {
+		if err := c.convStore.Delete(from); err != nil {
+			c.logger.Warnf("Failed to delete conversation %s: %v", from, err)
 		}
-		if askOpts[0].ParentID != "" {
-			parentId = askOpts[0].ParentID
+	}
+}
+
+// recordAccessTokenTurn adds the prompt and the final assistant message of an access token mode response
+// to the conversation matching the returned conversation ID, and to its tree if it was fetched, under the parent of the payload.
+// With Config.ServerSideOnly, only the parent ID is kept since the backend has the history.
+func (c *Client) recordAccessTokenTurn(data map[string]interface{}, prompt string, response *ChatResponse) {
+	if response.ConversationID == "" {
+		return
+	}
+	c.cachedConversation(response.ConversationID)
+	c.mu.Lock()
+	conversation, exists := c.conversations[response.ConversationID]
+	conversation.ParentID = response.ParentID
+	conversation.Partial = response.Partial
+	now := time.Now()
+	turn := []Message{
+		{Role: "user", Content: prompt, ID: payloadMessageID(data), CreatedAt: now},
+		{Role: "assistant", Content: response.Message, ID: response.MessageID, CreatedAt: now},
+	}
+	if !c.serverOnly {
+		for _, message := range turn {
+			conversation.addMessage(message)
 		}
 	}
+	if conversation.Tree != nil {
+		parentID, _ := data["parent_message_id"].(string)
+		question, answer := turn[0], turn[1]
+		conversation.Tree = conversation.Tree.withTurn(parentID,
+			TreeNode{ID: payloadMessageID(data), Message: &question},
+			TreeNode{ID: response.MessageID, Message: &answer})
+	}
+	c.conversations[response.ConversationID] = conversation
+	c.touchGenerated(response.ConversationID, payloadConversationID(data) == "")
+	c.mu.Unlock()
+	if !exists {
+		c.conversationCreated(response.ConversationID, conversation)
+	}
+	c.saveConversation(response.ConversationID, conversation)
+	for _, message := range turn {
+		c.emitMessage(response.ConversationID, message)
+	}
+}
 
-	// Construct the payload for the POST request
+// Continue resumes an assistant response that was cut off (FinishReason "max_tokens") in access token mode.
+// parentID is the MessageID of the cut off response, and the returned ChatResponse only contains its continuation.
+func (c *Client) Continue(ctx context.Context, conversationID, parentID string) (*ChatResponse, error) {
+	if err := c.checkAccessTokenMode("continue"); err != nil {
+		return nil, err
+	}
+	if conversationID == "" || parentID == "" {
+		return nil, fmt.Errorf("conversation ID and parent ID are required to continue a response")
+	}
+	return c.continueResponse(ctx, conversationID, parentID)
+}
+
+// continueResponse sends the "continue" action for the given conversation and parent message.
+func (c *Client) continueResponse(ctx context.Context, conversationID, parentID string, askOpts ...AskOpts) (*ChatResponse, error) {
 	data := map[string]interface{}{
-		"action": "next",
-		"messages": []map[string]interface{}{
-			{
-				"id":   genUUID(),
-				"role": "user",
-				"content": map[string]interface{}{
-					"content_type": "text",
-					"parts":        []string{prompt},
-				},
-			},
-		},
-		"model": c.engine,
+		"action":            "continue",
+		"conversation_id":   conversationID,
+		"parent_message_id": parentID,
+		"model":             c.conversationEngine(conversationID),
 	}
+	return c.postConversation(ctx, data, askOpts...)
+}
 
-	// Add the conversation ID and parent ID to the payload, if provided
-	if conversationId != "" {
-		data["conversation_id"] = conversationId
+// postConversation sends the given payload to the conversation endpoint and returns the last message in the response.
+func (c *Client) postConversation(ctx context.Context, data map[string]interface{}, askOpts ...AskOpts) (*ChatResponse, error) {
+	if c.GetStream() {
+		return c.readConversationStream(ctx, data, askOpts...)
 	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 
-	if parentId != "" {
-		data["parent_message_id"] = parentId
-	} else {
-		data["parent_message_id"] = genUUID()
+	// Add an Arkose token to the payload, if required by the model
+	if err := c.setArkoseToken(ctx, data); err != nil {
+		return nil, err
 	}
 
 	// Convert the payload to JSON and create a new HTTP request
@@ -406,19 +1573,32 @@ func (c *Client) askWithAccessToken(ctx context.Context, prompt string, askOpts
 
 	// Set the authorization header using the access token
 	c.setHeaders(req, c.auth.accessToken)
+	c.setBrowserHeaders(req)
+	setRequestOpts(req, askOpts...)
+	c.logRequest(req, string(payload))
 
 	// Send the HTTP request and handle the response
-	resp, err := http.DefaultClient.Do(req)
+	start := time.Now()
+	resp, err := c.httpx.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("system error: %w", err)
+		c.emitRequest(payloadEngine(data), 0, start, 0, 0)
+		return nil, c.emitFailure(fmt.Errorf("system error: %w", err))
 	}
 
 	// Close the response body when we're done with it
 	defer resp.Body.Close()
+	c.logResponse(resp, "")
+	c.emitRequest(payloadEngine(data), resp.StatusCode, start, 0, 0)
 
 	if resp.StatusCode == http.StatusOK {
+		// Switch to the WebSocket stream if the backend returned a wss_url
+		body, err := c.openResponseBody(ctx, resp)
+		if err != nil {
+			return nil, err
+		}
+
 		// Parse the response body and return the last message in the conversation
-		msgs, err := c.parseResponse(resp.Body, nil)
+		msgs, err := c.parseResponse(body, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -430,11 +1610,265 @@ func (c *Client) askWithAccessToken(ctx context.Context, prompt string, askOpts
 
 	// If the API returned an error, return a ChatError containing the error message and HTTP status code
 	body, _ := io.ReadAll(resp.Body)
-	return nil, &ChatError{Message: string(body), Code: resp.StatusCode}
+	if err := c.checkArkoseError(resp.StatusCode, string(body)); err != nil {
+		return nil, err
+	}
+	return nil, c.emitFailure(&ChatError{Message: string(body), Code: resp.StatusCode, RetryAfter: retryAfter(resp.Header)})
+}
+
+// readConversationStream sends the given payload to the conversation endpoint like postConversation, but reads the
+// response as a stream like AskStream, see Config.Stream: the request timeout only applies until the response headers
+// arrive and the stream is cancelled by Close. The last message is returned, its Elapsed being the time to the last token.
+func (c *Client) readConversationStream(ctx context.Context, data map[string]interface{}, askOpts ...AskOpts) (*ChatResponse, error) {
+	start := time.Now()
+	body, err := c.openConversationStream(ctx, data, askOpts...)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	msgs, err := c.parseResponse(body, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("stream ended without a response")
+	}
+	last := msgs[len(msgs)-1]
+	if last.ReceivedAt != nil {
+		last.Elapsed = last.ReceivedAt.Sub(start)
+	}
+	return last, nil
 }
 
 // askStreamWithAccessToken sends a question to Custom API using the specified conversation ID or the default one.
 func (c *Client) askStreamWithAccessToken(ctx context.Context, prompt string, ch chan *ChatResponse, askOpts ...AskOpts) error {
+	// The backend doesn't support stop sequences, they are applied client side
+	stop, err := c.stopSequences(askOpts...)
+	if err != nil {
+		return err
+	}
+
+	// Construct the payload for the POST request and open the stream
+	data := c.makeAccessTokenPayload(prompt, askOpts...)
+	language := c.replyLanguage(prompt)
+	start := time.Now()
+	body, err := c.openConversationStream(ctx, data, askOpts...)
+	var goneID string // the conversation lost by the backend, if recreated
+	if id := payloadConversationID(data); id != "" && isConversationGone(err) {
+		if !c.autoRecreate {
+			return fmt.Errorf("%w: %s: %w", ErrRemoteConversationGone, id, err)
+		}
+		c.logger.Infof("Conversation %s is gone on the backend, starting a new one", id)
+		goneID = id
+		data = c.recreatePayload(data, prompt, askOpts...)
+		body, err = c.openConversationStream(ctx, data, askOpts...)
+	}
+	if err != nil {
+		return err
+	}
+	recreated := goneID != ""
+
+	// Forward the messages to the channel, recording the final message in the conversation once the stream completes
+	messages := make(chan *ChatResponse, cap(ch))
+	go func() {
+		var last *ChatResponse
+		var abandoned error // why the messages are no longer sent, the stream is then aborted
+		stopped := false
+		record := func() {
+			// The history of a recreated conversation moves to the new conversation before its first turn
+			if goneID != "" {
+				c.moveConversation(goneID, last.ConversationID)
+				goneID = ""
+			}
+			c.recordAccessTokenTurn(data, prompt, last)
+		}
+		send := func(message *ChatResponse) {
+			if abandoned == nil {
+				if abandoned = sendStream(ctx, ch, message, c.slowConsumer); abandoned != nil {
+					body.release()
+				}
+			}
+		}
+		fail := func(err error) {
+			// Keep the response received so far with the error of a failed stream
+			failed := &ChatResponse{Err: err}
+			if errors.Is(abandoned, ErrSlowConsumer) {
+				failed.Err = abandoned
+				c.logger.Warnf("Aborted a stream not read for %s", c.slowConsumer)
+			}
+			if last != nil {
+				partial := *last
+				partial.Err = failed.Err
+				partial.Partial = true
+				failed = &partial
+				last = failed
+				record()
+			}
+			if abandoned != nil {
+				replaceOldest(ch, failed)
+			} else {
+				send(failed)
+			}
+		}
+		for message := range messages {
+			if stopped || (abandoned != nil && message.Err == nil) {
+				continue // drain the messages left after a stop sequence or an abort
+			}
+			if message.Err != nil {
+				fail(message.Err)
+				continue
+			}
+			message.Message = c.redactResponse(message.Message)
+			if message.ReceivedAt != nil {
+				message.Elapsed = message.ReceivedAt.Sub(start)
+			}
+			message.Language = language
+			message.Recreated = recreated
+			if truncated, ok := truncateAtStop(message.Message, stop); ok {
+				// Stop the stream at the first stop sequence
+				message.Message = truncated
+				stopped = true
+				body.release()
+			}
+			last = message
+			send(message)
+		}
+		if errors.Is(abandoned, ErrSlowConsumer) && (last == nil || !last.Partial) {
+			// The stream was read to the end before the release of its body could fail it
+			fail(abandoned)
+		} else if last != nil && !last.Partial {
+			record()
+		}
+		close(ch)
+	}()
+
+	// Parse the response body and send any messages to the channel, releasing the stream once the body is closed
+	_, err = c.parseResponse(body, messages)
+	if err != nil {
+		close(messages)
+		body.Close()
+	}
+	return err
+}
+
+// openConversationStream sends a conversation payload to the backend and returns the streamed response body.
+// The stream is tracked so that it can be cancelled when the client is closed, until the body is closed.
+func (c *Client) openConversationStream(ctx context.Context, data map[string]interface{}, askOpts ...AskOpts) (*streamBody, error) {
+	// Track the stream so that it can be cancelled when the client is closed
+	ctx, cancel := context.WithCancel(ctx)
+	id := c.trackStream(cancel)
+	untrack := c.trackConversation(payloadConversationID(data), cancel)
+	release := func() {
+		c.untrackStream(id)
+		untrack()
+		cancel()
+	}
+
+	// Add an Arkose token to the payload, if required by the model
+	if err := c.setArkoseToken(ctx, data); err != nil {
+		release()
+		return nil, err
+	}
+
+	// Convert the payload to JSON and create a new HTTP request
+	payload, _ := json.Marshal(data)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseUrl, strings.NewReader(string(payload)))
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("system error: %w", err)
+	}
+
+	// Set the authorization header using the access token
+	c.setHeaders(req, c.auth.accessToken)
+	c.setBrowserHeaders(req)
+	setRequestOpts(req, askOpts...)
+	c.logRequest(req, string(payload))
+
+	// Send the HTTP request and handle the response, the request timeout only applies until the response headers arrive
+	var timer *time.Timer
+	if c.requestTimeout > 0 {
+		timer = time.AfterFunc(c.requestTimeout, cancel)
+	}
+	start := time.Now()
+	resp, err := c.httpx.Do(req)
+	if timer != nil {
+		timer.Stop()
+	}
+	if err != nil {
+		release()
+		c.emitRequest(payloadEngine(data), 0, start, 0, 0)
+		return nil, c.emitFailure(fmt.Errorf("system error: %w", err))
+	}
+	c.logResponse(resp, "")
+	c.emitRequest(payloadEngine(data), resp.StatusCode, start, 0, 0)
+
+	if resp.StatusCode == http.StatusOK {
+		// Switch to the WebSocket stream if the backend returned a wss_url
+		respBody, err := c.openResponseBody(ctx, resp)
+		if err != nil {
+			resp.Body.Close()
+			release()
+			return nil, err
+		}
+
+		return &streamBody{ReadCloser: respBody, onClose: release}, nil
+	} else {
+		// Return a ChatError containing the error message and HTTP status code
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		release()
+		if err := c.checkArkoseError(resp.StatusCode, string(body)); err != nil {
+			return nil, err
+		}
+		return nil, c.emitFailure(&ChatError{Message: string(body), Code: resp.StatusCode, RetryAfter: retryAfter(resp.Header)})
+	}
+}
+
+// payloadEngine returns the model of an access token mode conversation payload.
+func payloadEngine(data map[string]interface{}) string {
+	engine, _ := data["model"].(string)
+	return engine
+}
+
+// payloadMessageID returns the ID of the user message of an access token mode conversation payload.
+func payloadMessageID(data map[string]interface{}) string {
+	messages, _ := data["messages"].([]map[string]interface{})
+	if len(messages) == 0 {
+		return ""
+	}
+	id, _ := messages[len(messages)-1]["id"].(string)
+	return id
+}
+
+// payloadConversationID returns the conversation ID of an access token mode conversation payload, empty for a new one.
+func payloadConversationID(data map[string]interface{}) string {
+	id, _ := data["conversation_id"].(string)
+	return id
+}
+
+// streamBody wraps a streamed response body and calls onClose once the body is closed.
+type streamBody struct {
+	io.ReadCloser
+	onClose func()
+	once    sync.Once
+}
+
+// Close closes the underlying body and calls onClose.
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// release calls onClose if it wasn't called yet, cancelling the stream without closing the body.
+func (b *streamBody) release() {
+	b.once.Do(b.onClose)
+}
+
+// makeAccessTokenPayload returns the conversation payload for the given prompt, used in access token mode.
+// When a new conversation is started, the configured init message is sent as the first user message.
+func (c *Client) makeAccessTokenPayload(prompt string, askOpts ...AskOpts) map[string]interface{} {
 	var conversationId string
 	var parentId string
 
@@ -448,20 +1882,36 @@ func (c *Client) askStreamWithAccessToken(ctx context.Context, prompt string, ch
 		}
 	}
 
-	// Construct the payload for the POST request
+	// Continue from the last known message of the conversation when no parent ID is given
+	var conversation Conversation
+	if conversationId != "" {
+		conversation, _ = c.cachedConversation(conversationId)
+	}
+	if conversationId != "" && parentId == "" {
+		parentId = conversation.ParentID
+	}
+
+	messages := make([]map[string]interface{}, 0, 3)
+	// Inject the init message at the start of new conversations, it is not re-sent on follow-ups
+	if conversationId == "" && c.initMessage != "" && !c.noInitMessage {
+		messages = append(messages, makeAccessTokenMessage(c.initMessage))
+	}
+	// Pin the reply language with a system message sent before the prompt, it is not recorded in the conversation
+	if language := c.replyLanguage(prompt); language != "" {
+		instruction := makeAccessTokenMessage(replyLanguageInstruction(language))
+		instruction["role"] = "system"
+		messages = append(messages, instruction)
+	}
+	// Have the model finish a previous answer cut off by a failed stream, the prompt is still recorded as is
+	if len(askOpts) > 0 && askOpts[0].ResumePartial && conversation.Partial {
+		prompt = resumePartialPrompt(conversation.LastMessage, prompt)
+	}
+	messages = append(messages, makeAccessTokenMessage(prompt))
+
 	data := map[string]interface{}{
-		"action": "next",
-		"messages": []map[string]interface{}{
-			{
-				"id":   genUUID(),
-				"role": "user",
-				"content": map[string]interface{}{
-					"content_type": "text",
-					"parts":        []string{prompt},
-				},
-			},
-		},
-		"model": c.engine,
+		"action":   "next",
+		"messages": messages,
+		"model":    c.conversationEngine(conversationId),
 	}
 
 	// Add the conversation ID and parent ID to the payload, if provided
@@ -474,30 +1924,32 @@ func (c *Client) askStreamWithAccessToken(ctx context.Context, prompt string, ch
 	} else {
 		data["parent_message_id"] = genUUID()
 	}
+	return data
+}
 
-	// Convert the payload to JSON and create a new HTTP request
-	payload, _ := json.Marshal(data)
-	req, err := http.NewRequestWithContext(ctx, "POST", c.baseUrl, strings.NewReader(string(payload)))
-	if err != nil {
-		return fmt.Errorf("system error: %w", err)
-	}
-
-	// Set the authorization header using the access token
-	c.setHeaders(req, c.auth.accessToken)
+// The number of characters of a partial answer quoted by resumePartialPrompt.
+const resumeTailLength = 200
 
-	// Send the HTTP request and handle the response
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("system error: %w", err)
+// resumePartialPrompt returns prompt preceded by the request to continue the partial answer ending with tail, if known.
+func resumePartialPrompt(tail, prompt string) string {
+	if runes := []rune(tail); len(runes) > resumeTailLength {
+		tail = "..." + string(runes[len(runes)-resumeTailLength:])
 	}
+	if tail == "" {
+		return "Your previous answer was interrupted. First continue it from where it stopped, then reply to this message:\n\n" + prompt
+	}
+	return fmt.Sprintf("Your previous answer was interrupted, it ended with:\n\n%s\n\nFirst continue it from there, then reply to this message:\n\n%s", tail, prompt)
+}
 
-	if resp.StatusCode == http.StatusOK {
-		// Parse the response body and send any messages to the channel
-		_, err := c.parseResponse(resp.Body, ch)
-		return err
-	} else {
-		// Return a ChatError containing the HTTP status code
-		return &ChatError{Code: resp.StatusCode}
+// makeAccessTokenMessage returns a user message in the format expected by the conversation endpoint.
+func makeAccessTokenMessage(content string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   genUUID(),
+		"role": "user",
+		"content": map[string]interface{}{
+			"content_type": "text",
+			"parts":        []string{content},
+		},
 	}
 }
 
@@ -507,7 +1959,9 @@ func (c *Client) parseResponse(response io.ReadCloser, streamChannel chan *ChatR
 	messages := make([]*ChatResponse, 0)
 	var err error
 
-	// Create a scanner to read the response body
+	// Create a scanner to read the response body, decompressing it if a proxy gzipped it without saying so,
+	// and closing it if it stalls
+	response = sniffGzip(c.withIdleTimeout(response))
 	scanner := bufio.NewScanner(response)
 
 	// If the first line contains {"detail": }, return an error
@@ -535,6 +1989,8 @@ func (c *Client) parseResponse(response io.ReadCloser, streamChannel chan *ChatR
 // if streamChannel is not nil, it will send the messages to the channel as they are received
 func (c *Client) startScan(scanner *bufio.Scanner, streamChannel chan *ChatResponse, respBody io.ReadCloser) ([]*ChatResponse, error) {
 	var messages []*ChatResponse
+	var attachments []Attachment
+	var err error
 	defer respBody.Close()
 
 	// Loop through each line in the response body
@@ -553,8 +2009,8 @@ func (c *Client) startScan(scanner *bufio.Scanner, streamChannel chan *ChatRespo
 
 		// Handle error messages that contain {"detail": }
 		if strings.Contains(line, `{"detail":`) {
-			message := regexp.MustCompile(`{"detail":.*}`).FindString(line)
-			return nil, fmt.Errorf(message)
+			err = fmt.Errorf(regexp.MustCompile(`{"detail":.*}`).FindString(line))
+			break
 		}
 
 		// Remove "data: " prefix from line
@@ -567,49 +2023,73 @@ func (c *Client) startScan(scanner *bufio.Scanner, streamChannel chan *ChatRespo
 
 		// Parse the line as JSON and check if it contains the necessary fields
 		var parsedLine map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &parsedLine); err != nil || !checkFields(parsedLine) {
+		if err := json.Unmarshal([]byte(line), &parsedLine); err != nil {
+			c.logger.Debugf("Skipping malformed line: %s", c.redactResponse(line))
+			continue
+		}
+		if !checkFields(parsedLine) {
 			continue
 		}
 
-		// Extract message content and check if it is of type "text"
-		content := parsedLine["message"].(map[string]interface{})["content"].(map[string]interface{})
-		if messageContextType, ok := content["content_type"].(string); ok && messageContextType == "text" {
-			parts := content["parts"].([]interface{})
-
-			// Only process messages that have at least one part
-			if len(parts) > 0 {
-				message := fmt.Sprintf("%v", parts[0])
-				conversationID := parsedLine["conversation_id"].(string)
-				parentID := parsedLine["message"].(map[string]interface{})["id"].(string)
-
-				// If streamChannel is not nil, send the message to the channel
-				if streamChannel != nil && message != "" {
-					streamChannel <- &ChatResponse{
-						ConversationID: conversationID,
-						ParentID:       parentID,
-						Message:        strings.TrimSpace(message),
-					}
-					continue
-				}
-
-				// Add the message to the messages slice
-				messages = append(messages, &ChatResponse{
-					ConversationID: conversationID,
-					ParentID:       parentID,
-					Message:        strings.TrimSpace(message),
-				})
-			}
-		} else {
+		// Extract the text and the attachments of the message, the attachments are accumulated over the response
+		messageData, _ := parsedLine["message"].(map[string]interface{})
+		content, _ := messageData["content"].(map[string]interface{})
+		message, images, ok := messageContent(content)
+		if !ok {
 			// Log a warning for unsupported message types
-			c.logger.Warn("Unsupported message type: " + messageContextType)
+			c.logger.Warnf("Unsupported message type: %v", content["content_type"])
+			continue
+		}
+		attachments = mergeAttachments(attachments, images...)
+		attachments = mergeAttachments(attachments, messageCitations(messageData)...)
+		if message == "" && len(attachments) == 0 {
+			continue
+		}
+
+		conversationID, ok := parsedLine["conversation_id"].(string)
+		if !ok {
+			c.logger.Debug("Skipping message without a conversation ID")
+			continue
+		}
+		messageID, ok := messageData["id"].(string)
+		if !ok {
+			c.logger.Debug("Skipping message without a message ID")
+			continue
 		}
+		receivedAt := time.Now()
+		response := &ChatResponse{
+			ConversationID: conversationID,
+			ParentID:       messageID,
+			MessageID:      messageID,
+			Message:        strings.TrimSpace(message),
+			FinishReason:   getFinishReason(messageData),
+			ReceivedAt:     &receivedAt,
+		}
+		if len(attachments) > 0 {
+			response.Attachments = append([]Attachment(nil), attachments...)
+		}
+
+		// If streamChannel is not nil, send the message to the channel, otherwise add it to the messages slice
+		if streamChannel != nil {
+			streamChannel <- response
+			continue
+		}
+		messages = append(messages, response)
 	}
 
-	// Close the streamChannel and return the messages slice
+	// Report read errors, such as connection errors or abnormal closures
+	if err == nil {
+		err = scanner.Err()
+	}
+
+	// Send the error as the last message and close the streamChannel, then return the messages slice
 	if streamChannel != nil {
+		if err != nil {
+			streamChannel <- &ChatResponse{Err: err}
+		}
 		close(streamChannel)
 	}
-	return messages, nil
+	return messages, err
 }
 
 // checkFields checks if the necessary fields exist in the parsed line map
@@ -636,6 +2116,20 @@ func checkFields(parsedLine map[string]interface{}) bool {
 	return true
 }
 
+// getFinishReason returns the finish_details type from the message metadata, if present
+func getFinishReason(messageData map[string]interface{}) string {
+	metadata, ok := messageData["metadata"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	finishDetails, ok := metadata["finish_details"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	finishReason, _ := finishDetails["type"].(string)
+	return finishReason
+}
+
 // genUUID generates a random UUID.
 func genUUID() string {
 	uuid := make([]byte, 16)
@@ -0,0 +1,61 @@
+package chatgpt
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseResponseSkipsMalformedLines(t *testing.T) {
+	body := strings.Join([]string{
+		"",
+		"event: ping",
+		`data: {not json`,
+		`data: {"message": null, "conversation_id": "c1"}`,
+		`data: {"message": {"id": "m1", "content": null}, "conversation_id": "c1"}`,
+		`data: {"message": {"id": "m1", "content": {"content_type": "text", "parts": []}}, "conversation_id": "c1"}`,
+		`data: {"message": {"id": "m1", "content": {"content_type": "text", "parts": ["no conversation"]}}}`,
+		`data: {"message": {"id": "m1", "content": {"content_type": "text", "parts": ["bad conversation"]}}, "conversation_id": 42}`,
+		`data: {"message": {"content": {"content_type": "text", "parts": ["no message ID"]}}, "conversation_id": "c1"}`,
+		`data: {"message": {"id": "m1", "content": {"content_type": "unknown", "parts": [{"x": 1}]}}, "conversation_id": "c1"}`,
+		`data: {"message": {"id": "m1", "content": {"content_type": "text", "parts": ["Hello"]}, "metadata": {"finish_details": {"type": "stop"}}}, "conversation_id": "c1"}`,
+		"data: [DONE]",
+		"",
+	}, "\n")
+	client := NewClient(&Config{ApiKey: "sk-test", LogLevel: LogLevelError})
+	messages, err := client.parseResponse(io.NopCloser(strings.NewReader(body)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want only the valid one: %+v", len(messages), messages)
+	}
+	if got := messages[0]; got.Message != "Hello" || got.ConversationID != "c1" || got.MessageID != "m1" || got.FinishReason != "stop" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestParseResponseErrors(t *testing.T) {
+	client := NewClient(&Config{ApiKey: "sk-test", LogLevel: LogLevelError})
+
+	// An error on the first line fails the request
+	_, err := client.parseResponse(io.NopCloser(strings.NewReader(`{"detail":"Too many requests"}`+"\n")), nil)
+	if err == nil || !strings.Contains(err.Error(), "Too many requests") {
+		t.Errorf("got error %v for a first line error", err)
+	}
+
+	// An error in the stream is sent as the last message of the channel, which is then closed
+	body := "\n" + `data: {"message": {"id": "m1", "content": {"content_type": "text", "parts": ["Hi"]}}, "conversation_id": "c1"}` +
+		"\n" + `data: {"detail":"Something went wrong"}` + "\n"
+	ch := make(chan *ChatResponse, 4)
+	if _, err := client.parseResponse(io.NopCloser(strings.NewReader(body)), ch); err != nil {
+		t.Fatal(err)
+	}
+	var received []*ChatResponse
+	for message := range ch {
+		received = append(received, message)
+	}
+	if len(received) != 2 || received[0].Message != "Hi" || received[1].Err == nil {
+		t.Errorf("got %+v, want the message then the error", received)
+	}
+}
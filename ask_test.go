@@ -0,0 +1,315 @@
+package chatgpt_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+func TestAskBatchSharedConversation(t *testing.T) {
+	server := chatgpttest.NewServer()
+	defer server.Close()
+	prompts := make([]string, 5)
+	for i := range prompts {
+		prompts[i] = fmt.Sprintf("question %d", i)
+		server.AddResponses(chatgpttest.Response{Message: fmt.Sprintf("answer %d", i)})
+	}
+	client := startApiKeyClient(t, server, chatgpt.Config{})
+
+	_, errs := client.AskBatch(context.Background(), prompts, len(prompts), chatgpt.AskOpts{ConversationID: "shared"})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each question is sent with the turns of the previous ones, none of them is lost
+	conversation, err := client.GetConversation("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(conversation.Messages), 1+2*len(prompts); got != want {
+		t.Errorf("got %d messages in the conversation, want %d", got, want)
+	}
+	var sent []int
+	for _, request := range server.Requests() {
+		sent = append(sent, len(request.Messages()))
+	}
+	sort.Ints(sent)
+	for i, count := range sent {
+		if want := 2 + 2*i; count != want {
+			t.Errorf("got requests with %v messages, want 2, 4, 6...", sent)
+			break
+		}
+	}
+}
+
+func TestAskRequestIDs(t *testing.T) {
+	server := chatgpttest.NewServer(
+		chatgpttest.Response{Message: "Hello"},
+		chatgpttest.Response{Err: errors.New("overloaded"), Status: http.StatusServiceUnavailable},
+	)
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{})
+
+	response, err := client.Ask(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.RequestID != "req_1" || response.SystemFingerprint != "fp_chatgpttest" {
+		t.Errorf("got request ID %q and system fingerprint %q", response.RequestID, response.SystemFingerprint)
+	}
+
+	// The request ID of a failed request is kept for OpenAI support
+	_, err = client.Ask(context.Background(), "Hi again")
+	var chatErr *chatgpt.ChatError
+	if !errors.As(err, &chatErr) || chatErr.RequestID != "req_2" || !strings.Contains(err.Error(), "req_2") {
+		t.Errorf("got error %v, want the ID of the failed request", err)
+	}
+}
+
+func TestAskWithConfigStream(t *testing.T) {
+	// The reply takes longer than the request timeout, which only applies until the first bytes of a stream
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "a slowly streamed reply"}, chatgpttest.Response{Message: "a reply far too slow to arrive in time"})
+	server.ChunkDelay = 40 * time.Millisecond
+	defer server.Close()
+	client := startAccessTokenClient(t, server, chatgpt.Config{Stream: true, RequestTimeout: 100 * time.Millisecond})
+
+	response, err := client.Ask(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Message != "a slowly streamed reply" {
+		t.Errorf("got %q", response.Message)
+	}
+	conversation, err := client.GetConversation(response.ConversationID)
+	if err != nil || len(conversation.Messages) == 0 || conversation.LastMessage != "a slowly streamed reply" {
+		t.Errorf("got conversation %+v and error %v, want the streamed turn", conversation, err)
+	}
+
+	// Without streaming, the timeout applies to the whole response
+	client.ToggleStream(false)
+	if _, err := client.Ask(context.Background(), "Hi again"); err == nil {
+		t.Error("got no error for a response slower than the timeout")
+	}
+}
+
+func TestAskStreamRecordsTurns(t *testing.T) {
+	server := chatgpttest.NewServer(
+		chatgpttest.Response{Message: "Streamed answer"},
+		chatgpttest.Response{Message: "Follow-up answer"},
+	)
+	defer server.Close()
+	client := startAccessTokenClient(t, server, chatgpt.Config{})
+
+	ch, err := client.AskStream(context.Background(), "Question")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last *chatgpt.ChatResponse
+	for message := range ch {
+		last = message
+	}
+	if last == nil || last.Err != nil {
+		t.Fatalf("got last message %+v", last)
+	}
+
+	conversation, err := client.GetConversation(last.ConversationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := conversation.Messages
+	if len(messages) < 2 || messages[len(messages)-2].Content != "Question" || messages[len(messages)-1].Content != "Streamed answer" ||
+		messages[len(messages)-1].ID != last.MessageID || conversation.ParentID != last.MessageID {
+		t.Errorf("got conversation %+v, want the streamed turn", conversation)
+	}
+
+	// The follow-up question continues the streamed turn
+	if _, err := client.Ask(context.Background(), "Follow-up", chatgpt.AskOpts{ConversationID: last.ConversationID}); err != nil {
+		t.Fatal(err)
+	}
+	body := server.Requests()[1].Body
+	if body["conversation_id"] != last.ConversationID || body["parent_message_id"] != last.MessageID {
+		t.Errorf("got conversation %v and parent %v, want the streamed turn", body["conversation_id"], body["parent_message_id"])
+	}
+}
+
+func TestAskHeadersAndCookies(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Hi"}, chatgpttest.Response{Message: "Hi"})
+	defer server.Close()
+	opts := chatgpt.AskOpts{
+		Headers: map[string]string{"X-Api-Key": "per-call", "X-Shared": "per-call"},
+		Cookies: []*http.Cookie{{Name: "session", Value: "abc"}},
+	}
+
+	apiClient := startApiKeyClient(t, server, chatgpt.Config{})
+	if _, err := apiClient.Ask(context.Background(), "Hi", opts); err != nil {
+		t.Fatal(err)
+	}
+	tokenClient := startAccessTokenClient(t, server, chatgpt.Config{ExtraHeaders: map[string]string{"X-Shared": "client", "X-Client": "client"}})
+	ch, err := tokenClient.AskStream(context.Background(), "Hi", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests", len(requests))
+	}
+	for i, request := range requests {
+		if request.Header.Get("X-Api-Key") != "per-call" || request.Header.Get("Cookie") != "session=abc" {
+			t.Errorf("request %d: got headers %v", i, request.Header)
+		}
+	}
+	// The per-call headers win over the client ones, which are kept otherwise
+	if header := requests[1].Header; header.Get("X-Shared") != "per-call" || header.Get("X-Client") != "client" {
+		t.Errorf("got headers %v, want the per-call ones merged over the client ones", header)
+	}
+}
+
+func TestConfigHeaders(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Hi"}, chatgpttest.Response{Message: "Hi"})
+	defer server.Close()
+	headers := map[string]string{"X-Proxy-Token": "secret", "X-Shared": "config", "User-Agent": "custom/1.0"}
+
+	// Unlike the extra headers, Headers are also sent in API key mode
+	apiClient := startApiKeyClient(t, server, chatgpt.Config{Headers: headers, ExtraHeaders: map[string]string{"X-Extra": "extra"}})
+	apiClient.SetHeader("X-Set", "set")
+	if _, err := apiClient.Ask(context.Background(), "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	tokenClient := startAccessTokenClient(t, server, chatgpt.Config{Headers: headers, ExtraHeaders: map[string]string{"X-Shared": "extra"}})
+	ch, err := tokenClient.AskStream(context.Background(), "Hi", chatgpt.AskOpts{Headers: map[string]string{"X-Proxy-Token": "per-call"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+
+	requests := server.Requests()
+	if header := requests[0].Header; header.Get("X-Proxy-Token") != "secret" || header.Get("User-Agent") != "custom/1.0" ||
+		header.Get("X-Set") != "set" || header.Get("X-Extra") != "" {
+		t.Errorf("got API key headers %v", header)
+	}
+	// The extra headers override Headers, and the per-call headers override both
+	if header := requests[1].Header; header.Get("X-Proxy-Token") != "per-call" || header.Get("X-Shared") != "extra" || header.Get("User-Agent") != "custom/1.0" {
+		t.Errorf("got access token headers %v", header)
+	}
+}
+
+func TestResponseReceivedAtJSON(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Hi"}, chatgpttest.Response{Message: "Hi"})
+	defer server.Close()
+
+	// Responses of API key mode aren't streamed, they have no reception time
+	response, err := startApiKeyClient(t, server, chatgpt.Config{}).Ask(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := json.Marshal(response); response.ReceivedAt != nil || strings.Contains(string(data), "received_at") {
+		t.Errorf("got %s for an API key response", data)
+	}
+
+	response, err = startAccessTokenClient(t, server, chatgpt.Config{}).AskStreamCollect(context.Background(), "Hi", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := json.Marshal(response); response.ReceivedAt == nil || !strings.Contains(string(data), `"received_at":"`) {
+		t.Errorf("got %s for a streamed response", data)
+	}
+}
+
+// lastPrompt returns the text of the last message of an access token request.
+func lastPrompt(request chatgpttest.Request) string {
+	messages, _ := request.Body["messages"].([]interface{})
+	if len(messages) == 0 {
+		return ""
+	}
+	content, _ := messages[len(messages)-1].(map[string]interface{})["content"].(map[string]interface{})
+	parts, _ := content["parts"].([]interface{})
+	if len(parts) == 0 {
+		return ""
+	}
+	text, _ := parts[0].(string)
+	return text
+}
+
+func TestAskRecreatesGoneConversations(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		gone := chatgpttest.Response{Err: errors.New("conversation not found"), Status: http.StatusNotFound}
+		server := chatgpttest.NewServer(gone, chatgpttest.Response{Message: "Recreated", ConversationID: "new"}, gone)
+		client := startAccessTokenClient(t, server, chatgpt.Config{AutoRecreateConversations: true})
+		var lost chatgpt.Conversation
+		lost.FromMessages([]chatgpt.Message{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Once upon a"}})
+		lost.ParentID = "parent"
+		lost.Partial = true
+		client.SetConversation("lost", lost)
+
+		var response *chatgpt.ChatResponse
+		var err error
+		opts := chatgpt.AskOpts{ConversationID: "lost", ResumePartial: true, Headers: map[string]string{"X-Test": "kept"}}
+		if stream {
+			var ch chan *chatgpt.ChatResponse
+			if ch, err = client.AskStream(context.Background(), "Go on", opts); err == nil {
+				for response = range ch {
+					if !response.Recreated {
+						t.Errorf("stream: got a message not marked recreated %+v", response)
+					}
+				}
+				err = response.Err
+			}
+		} else {
+			response, err = client.Ask(context.Background(), "Go on", opts)
+		}
+		if err != nil {
+			t.Fatalf("stream %t: %v", stream, err)
+		}
+		if !response.Recreated || response.ConversationID != "new" || response.Message != "Recreated" {
+			t.Errorf("stream %t: got %+v", stream, response)
+		}
+
+		// The retry starts a new conversation with the same options, still resuming the partial answer
+		retry := server.Requests()[1]
+		if retry.Body["conversation_id"] != nil || retry.Body["parent_message_id"] == "parent" {
+			t.Errorf("stream %t: the retry continues the lost conversation: %v", stream, retry.Body)
+		}
+		if prompt := lastPrompt(retry); !strings.Contains(prompt, "Once upon a") || !strings.HasSuffix(prompt, "Go on") {
+			t.Errorf("stream %t: the retry doesn't resume the partial answer: %q", stream, prompt)
+		}
+		if retry.Header.Get("X-Test") != "kept" {
+			t.Errorf("stream %t: the retry lost the request headers", stream)
+		}
+
+		// The history moves to the new conversation
+		if client.HasConversation("lost") {
+			t.Errorf("stream %t: the lost conversation is still there", stream)
+		}
+		if conversation, err := client.GetConversation("new"); err != nil || len(conversation.Messages) != 4 || conversation.Messages[2].Content != "Go on" {
+			t.Errorf("stream %t: got conversation %+v (%v)", stream, conversation, err)
+		}
+
+		// Without AutoRecreateConversations, the error is returned
+		strict := startAccessTokenClient(t, server, chatgpt.Config{})
+		strict.SetConversation("lost", lost)
+		if stream {
+			_, err = strict.AskStream(context.Background(), "Go on", chatgpt.AskOpts{ConversationID: "lost"})
+		} else {
+			_, err = strict.Ask(context.Background(), "Go on", chatgpt.AskOpts{ConversationID: "lost"})
+		}
+		if !errors.Is(err, chatgpt.ErrRemoteConversationGone) {
+			t.Errorf("stream %t: got error %v, want ErrRemoteConversationGone", stream, err)
+		}
+		server.Close()
+	}
+}
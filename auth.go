@@ -1,12 +1,19 @@
 package chatgpt
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
-	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,14 +32,68 @@ type Auth struct {
 	expires time.Time
 	// enableCache is used to enable or disable caching of access tokens
 	enableCache bool
-	// clientStarted keeps track of whether or not the client has been started
-	clientStarted bool
+	// store is where access tokens are cached, defaults to the gpt-cache.json file
+	store TokenStore
+	// retries is the number of attempts of each auth server call
+	retries int
+	// clientStarted keeps track of whether or not the client has been started, it is read without holding Client.startMu
+	clientStarted atomic.Bool
 	// sessionName is used to store the name of the session
 	sessionName string
+	// baseUrl is the base URL of the token proxy used by the "proxy" auth method
+	baseUrl string
+	// method is the authentication method, either AuthMethodProxy or AuthMethodDirect
+	method string
+	// codeVerifier is the PKCE code verifier used by the "direct" auth method
+	codeVerifier string
+	// httpx is the HTTP client shared with the Client, so that the auth flow honors its proxy settings
+	httpx *http.Client
+	// setHeaders sets the browser headers configured on the Client on auth requests
+	setHeaders func(req *http.Request)
+	// jar is the cookie jar of the login, a new one is used for each attempt if it is nil
+	jar http.CookieJar
 }
 
+const (
+	// AuthMethodProxy authenticates through a token proxy deployment (see Config.AuthBaseURL).
+	AuthMethodProxy = "proxy"
+	// AuthMethodDirect authenticates directly against auth0.openai.com using the authorization code + PKCE flow.
+	AuthMethodDirect = "direct"
+)
+
+var (
+	// ErrCloudflareChallenge is returned when the login flow is blocked by a Cloudflare challenge page.
+	ErrCloudflareChallenge = errors.New("login blocked by a Cloudflare challenge, try the browser based TokenGen flow or a different network/proxy")
+	// ErrAuthServerUnreachable is returned when the auth server keeps failing with network errors or 5xx statuses.
+	ErrAuthServerUnreachable = errors.New("auth server unreachable")
+	// ErrBadCredentials is returned when the auth server rejects the email and password.
+	ErrBadCredentials = errors.New("bad credentials")
+	// ErrCaptchaRequired is returned when the login flow requires solving an Arkose captcha.
+	ErrCaptchaRequired = errors.New("login requires solving a captcha, try the browser based TokenGen flow to obtain an access token")
+)
+
+// The default number of attempts of each auth server call, see Config.AuthRetries.
+const DEFAULT_AUTH_RETRIES = 3
+
+// authRetryDelay is the delay before the first retry of an auth server call.
+const authRetryDelay = 500 * time.Millisecond
+
+// The default base URL of the token proxy used for email and password authentication, see Config.AuthBaseURL.
+var DEFAULT_AUTH_BASE_URL = "https://chat-api.ztorr.me"
+
+// The base URL of the OpenAI auth0 server, used by the login flow.
+var AUTH0_URL = "https://auth0.openai.com"
+
+const (
+	// auth0ClientID is the client ID of the official ChatGPT app, used by the direct auth method.
+	auth0ClientID = "pdlLIX2Y72MIl2rhLhTE9VV9bN905kBh"
+	// auth0RedirectUri is the redirect URI registered for auth0ClientID.
+	auth0RedirectUri = "com.openai.chat://auth0.openai.com/ios/com.openai.chat/callback"
+)
+
 // GetAccessToken generates and retrieves the OpenAI API access token by performing a series of authentication steps.
-func (a *Auth) GetAccessToken() (string, error) {
+// The steps are aborted when ctx is done.
+func (a *Auth) GetAccessToken(ctx context.Context) (string, error) {
 	if a.enableCache {
 		a.loadCachedAccessToken()
 	}
@@ -42,29 +103,57 @@ func (a *Auth) GetAccessToken() (string, error) {
 		return a.accessToken, nil
 	}
 
+	return a.refreshAccessToken(ctx)
+}
+
+// refreshAccessToken performs the authentication steps with email and password, ignoring any cached access token.
+// The token is only stored and cached once the whole flow succeeded, a cancelled flow leaves the cache untouched.
+func (a *Auth) refreshAccessToken(ctx context.Context) (string, error) {
 	// validate if email and password are set for authentication
 	if a.email == "" || a.password == "" {
 		return "", fmt.Errorf("email and password must be set to authenticate with OpenAI")
 	}
 
-	// get the callback URL after step one of authentication
-	callback_url, err := a.stepOne()
+	// pick the first and last steps of the flow based on the configured auth method
+	stepOne, stepThree := a.stepOne, a.stepThree
+	switch a.method {
+	case "", AuthMethodProxy:
+	case AuthMethodDirect:
+		stepOne, stepThree = a.stepOneDirect, a.stepThreeDirect
+	default:
+		return "", fmt.Errorf("unknown auth method: %s", a.method)
+	}
+
+	// get the callback URL after step one of authentication, retrying if the auth server is flaky
+	var callback_url string
+	err := a.withRetries(ctx, func() (err error) {
+		callback_url, err = stepOne(ctx)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
 	// get the URL for step two of authentication using the obtained callback URL along with email and password
-	code_url, err := a.stepTwo(callback_url, a.email, a.password)
+	code_url, err := a.stepTwo(ctx, callback_url, a.email, a.password)
 	if err != nil {
 		return "", err
 	}
 
 	// complete the final step of authentication and fetch the response containing the access token and its expiry time
-	resp, err := a.stepThree(code_url)
+	var resp *authResp
+	err = a.withRetries(ctx, func() (err error) {
+		resp, err = stepThree(ctx, code_url)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	// store the generated access token and its expiry in the Auth struct for future use
 	a.accessToken = resp.AccessToken
 	a.expires = resp.Expires
@@ -81,78 +170,59 @@ func (a *Auth) ExpiresIn() time.Duration {
 	return time.Until(a.expires)
 }
 
-type authCache struct {
-	AccessToken string    `json:"access_token,omitempty"`
-	Expires     time.Time `json:"expires,omitempty"`
+// cacheAccessToken saves the access token and its expiry to the token store.
+func (a *Auth) cacheAccessToken() error {
+	return a.tokenStore().Save(a.sessionName, a.accessToken, a.expires)
 }
 
-func (a *Auth) cacheAccessToken() error {
-	var previousData map[string]authCache
-	if _, err := os.Stat("gpt-cache.json"); err == nil {
-		if file, err := os.Open("gpt-cache.json"); err == nil {
-			defer file.Close()
-			json.NewDecoder(file).Decode(&previousData)
-		}
-	}
-	if previousData == nil {
-		previousData = make(map[string]authCache)
-	}
-	previousData[a.sessionName] = authCache{
-		AccessToken: a.accessToken,
-		Expires:     a.expires,
-	}
-	file, err := os.Create("gpt-cache.json")
-	if err != nil {
-		return err
+// loadCachedAccessToken loads the access token and its expiry from the token store, if one is saved for the session.
+// It returns true if a token was loaded.
+func (a *Auth) loadCachedAccessToken() bool {
+	token, expires, err := a.tokenStore().Load(a.sessionName)
+	if err != nil || token == "" {
+		return false // no cached token
 	}
-	defer file.Close()
-	return json.NewEncoder(file).Encode(previousData)
+	a.accessToken = token
+	a.expires = expires
+	return true
 }
 
-func (a *Auth) loadCachedAccessToken() {
-	if _, err := os.Stat("gpt-cache.json"); err != nil {
-		return // no cache file
-	}
-	file, err := os.Open("gpt-cache.json")
-	if err != nil {
-		return // error opening file
-	}
-	defer file.Close()
-	var data map[string]authCache
-	if err := json.NewDecoder(file).Decode(&data); err != nil {
-		return // error decoding file
-	}
-	if data == nil {
-		return // no data in file
-	}
-	if cache, ok := data[a.sessionName]; ok {
-		a.accessToken = cache.AccessToken
-		a.expires = cache.Expires
+// tokenStore returns the configured token store, or the file based one.
+func (a *Auth) tokenStore() TokenStore {
+	if a.store != nil {
+		return a.store
 	}
+	return defaultTokenStore
 }
 
-// copyCookies copies cookies from the source slice of http.Cookies to the destination http.Request.
-func (a *Auth) copyCookies(from []*http.Cookie, to *http.Request) {
-	// iterate over each cookie in the source slice
-	for _, cookie := range from {
-		// add the current cookie to the destination request
-		to.AddCookie(cookie)
+// cookieJar returns the cookie jar of a login attempt, the configured one or a new one.
+func (a *Auth) cookieJar() (http.CookieJar, error) {
+	if a.jar != nil {
+		return a.jar, nil
 	}
+	return cookiejar.New(nil)
 }
 
 // This function performs StepOne for authentication using the Auth struct provided
-func (a *Auth) stepOne() (string, error) {
+func (a *Auth) stepOne(ctx context.Context) (string, error) {
 
 	// Send a GET request to the authentication endpoint given and retrieve the response
-	resp, err := http.Get("https://chat-api.ztorr.me/auth/endpoint")
+	endpoint := a.authBaseUrl() + "/auth/endpoint"
+	req, _ := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	a.applyHeaders(req)
+	resp, err := a.httpClient().Do(req)
 	if err != nil {
-		return "", err
+		return "", &transientAuthError{fmt.Errorf("auth endpoint %s is unreachable: %w", endpoint, err)}
 	}
 	defer resp.Body.Close()
 
 	// Check if the status of the response is ok, return an error message if not
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status: %s", resp.Status)
+		err := fmt.Errorf("bad status from auth endpoint %s: %s", endpoint, resp.Status)
+		if isTransientStatus(resp.StatusCode) {
+			return "", &transientAuthError{err}
+		}
+		return "", err
 	}
 
 	// Decode the response body into a result variable that contains 'state' and 'url'
@@ -161,7 +231,7 @@ func (a *Auth) stepOne() (string, error) {
 		Url   string `json:"url"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+		return "", fmt.Errorf("invalid response from auth endpoint %s: %w", endpoint, err)
 	}
 
 	// Set the Authentication state to the 'state' received in the response
@@ -174,27 +244,35 @@ func (a *Auth) stepOne() (string, error) {
 // StepTwo performs authentication using the given url, email, and password.
 // It follows redirects, sets appropriate headers and cookies, and returns the final redirect URL,
 // or an error if any occurred during the process.
-func (a *Auth) stepTwo(auth_url, _email, _password string) (string, error) {
-	// create an http client with required cookie settings and redirect policy
+func (a *Auth) stepTwo(ctx context.Context, auth_url, _email, _password string) (string, error) {
+	// create an http client keeping the cookies across the redirect chain, which is followed manually
+	jar, err := a.cookieJar()
+	if err != nil {
+		return "", err
+	}
 	httpx := http.Client{
-		Jar: http.DefaultClient.Jar,
+		Transport: a.httpClient().Transport,
+		Jar:       jar,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
 	// prepare GET request for the specified authentication URL
-	req, _ := http.NewRequest("GET", auth_url, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", auth_url, nil)
+	a.applyHeaders(req)
 	resp, err := httpx.Do(req)
-	_ref_cookies := resp.Cookies()
-	_url_prefix := "https://auth0.openai.com"
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	_url_prefix := AUTH0_URL
 
 	// check if server responded with a redirect status
 	if resp.StatusCode != 302 {
+		if err := detectChallenge(resp); err != nil {
+			return "", err
+		}
 		return "", fmt.Errorf("bad status for url: %s", auth_url)
 	}
 
@@ -206,11 +284,10 @@ func (a *Auth) stepTwo(auth_url, _email, _password string) (string, error) {
 	form_data := `state=` + current_state + `&username=` + url.QueryEscape(_email) + `&js-available=true&webauthn-available=true&is-brave=false&webauthn-platform-available=false&action=default`
 
 	// prepare a POST request with the extracted form data and headers
-	req, _ = http.NewRequest("POST", next_url, strings.NewReader(form_data))
+	req, _ = http.NewRequestWithContext(ctx, "POST", next_url, strings.NewReader(form_data))
+	a.applyHeaders(req)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// copy cookies from the previous response to the current request
-	a.copyCookies(_ref_cookies, req)
 	resp, err = httpx.Do(req)
 	if err != nil {
 		return "", err
@@ -219,10 +296,13 @@ func (a *Auth) stepTwo(auth_url, _email, _password string) (string, error) {
 
 	// check for correct status code, and handle incorrect email/password combination error if received
 	if resp.StatusCode != 302 {
+		if err := detectChallenge(resp); err != nil {
+			return "", err
+		}
 		if resp.StatusCode == 400 {
-			return "", &ChatError{"email and password combination is incorrect or you have not verified your email address yet", 400}
+			return "", &ChatError{Message: "email and password combination is incorrect or you have not verified your email address yet", Code: 400}
 		}
-		return "", &ChatError{"bad status for url: " + next_url, resp.StatusCode}
+		return "", &ChatError{Message: "bad status for url: " + next_url, Code: resp.StatusCode}
 	}
 
 	// extract next URL from the response header and update the form data with provided password
@@ -230,11 +310,10 @@ func (a *Auth) stepTwo(auth_url, _email, _password string) (string, error) {
 	form_data = `state=` + current_state + `&username=` + url.QueryEscape(_email) + `&password=` + url.QueryEscape(_password) + `&action=default`
 
 	// prepare another POST request with the updated form data and headers
-	req, _ = http.NewRequest("POST", next_url, strings.NewReader(form_data))
+	req, _ = http.NewRequestWithContext(ctx, "POST", next_url, strings.NewReader(form_data))
+	a.applyHeaders(req)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// copy cookies from the previous response to the current request
-	a.copyCookies(_ref_cookies, req)
 	resp, err = httpx.Do(req)
 	if err != nil {
 		return "", err
@@ -243,16 +322,19 @@ func (a *Auth) stepTwo(auth_url, _email, _password string) (string, error) {
 
 	// check for correct status code after performing final redirect
 	if resp.StatusCode != 302 {
+		if err := detectChallenge(resp); err != nil {
+			return "", err
+		}
 		if resp.StatusCode == 400 {
-			return "", &ChatError{"email and password combination is incorrect or you have not verified your email address yet", 400}
+			return "", &ChatError{Message: "email and password combination is incorrect or you have not verified your email address yet", Code: 400}
 		}
-		return "", &ChatError{"bad status for url: " + next_url, resp.StatusCode}
+		return "", &ChatError{Message: "bad status for url: " + next_url, Code: resp.StatusCode}
 	}
 
 	// extract the final redirect URL and return it
 	next_url = _url_prefix + resp.Header.Get("Location")
-	req, _ = http.NewRequest("GET", next_url, nil)
-	a.copyCookies(_ref_cookies, req)
+	req, _ = http.NewRequestWithContext(ctx, "GET", next_url, nil)
+	a.applyHeaders(req)
 	resp, err = httpx.Do(req)
 	if err != nil {
 		return "", err
@@ -261,11 +343,28 @@ func (a *Auth) stepTwo(auth_url, _email, _password string) (string, error) {
 
 	// check for correct status code after visiting the final URL
 	if resp.StatusCode != 302 {
-		return "", &ChatError{"bad status for url: " + next_url, resp.StatusCode}
+		if err := detectChallenge(resp); err != nil {
+			return "", err
+		}
+		return "", &ChatError{Message: "bad status for url: " + next_url, Code: resp.StatusCode}
 	}
 	return resp.Header.Get("Location"), nil
 }
 
+// detectChallenge inspects the body of an unexpected response for Cloudflare challenges or Arkose captchas,
+// returning ErrCloudflareChallenge or ErrCaptchaRequired if one is found.
+func detectChallenge(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	page := string(body)
+	if strings.Contains(page, "cf-chl") || strings.Contains(page, "Just a moment") || resp.Header.Get("cf-mitigated") == "challenge" {
+		return ErrCloudflareChallenge
+	}
+	if strings.Contains(page, "arkose") || strings.Contains(page, "funcaptcha") {
+		return ErrCaptchaRequired
+	}
+	return nil
+}
+
 // AuthResp is a struct that represents the response returned by an authentication request.
 type authResp struct {
 	// AccessToken contains the access token string returned by the auth server.
@@ -278,27 +377,180 @@ type authResp struct {
 
 // StepThree completes the third step of the authentication process by exchanging the authorization
 // code for an access token, using the provided callback URL.
-func (a *Auth) stepThree(code_url string) (*authResp, error) {
+func (a *Auth) stepThree(ctx context.Context, code_url string) (*authResp, error) {
 	// Compose the data payload for the request.
 	var data = strings.NewReader(`state=` + a.authState + `&callbackUrl=` + url.QueryEscape(code_url))
 
 	// Create a new HTTP POST request object with the appropriate endpoint URL and data payload.
-	req, _ := http.NewRequest("POST", "https://chat-api.ztorr.me/auth/token", data)
+	endpoint := a.authBaseUrl() + "/auth/token"
+	req, _ := http.NewRequestWithContext(ctx, "POST", endpoint, data)
+	a.applyHeaders(req)
 	req.Header.Set("content-type", "application/x-www-form-urlencoded")
 
 	// Send the request and obtain the response.
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := a.httpClient().Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &transientAuthError{fmt.Errorf("auth endpoint %s is unreachable: %w", endpoint, err)}
 	}
 	defer resp.Body.Close()
+	if isTransientStatus(resp.StatusCode) {
+		return nil, &transientAuthError{fmt.Errorf("bad status from auth endpoint %s: %s", endpoint, resp.Status)}
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: auth endpoint %s returned %s", ErrBadCredentials, endpoint, resp.Status)
+	}
 
 	// Parse the response body as an AuthResp object.
 	var result authResp
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid response from auth endpoint %s (%s): %w", endpoint, resp.Status, err)
 	}
 
 	// Return the resulting AuthResp object and any error that occurred during the request/response cycle.
 	return &result, nil
 }
+
+// transientAuthError wraps an error of the auth server which may succeed on retry,
+// like a network error or a 5xx status.
+type transientAuthError struct {
+	err error
+}
+
+func (e *transientAuthError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientAuthError) Unwrap() error {
+	return e.err
+}
+
+// isTransientStatus returns true if the status code of an auth server response is worth retrying.
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// withRetries calls step until it succeeds or fails with an error which is not transient, up to the configured number of attempts.
+// The delay between attempts starts at authRetryDelay and doubles each time.
+// If all attempts fail, the returned error wraps ErrAuthServerUnreachable and the last error.
+// It stops retrying with the context error once ctx is done.
+func (a *Auth) withRetries(ctx context.Context, step func() error) error {
+	attempts := a.retries
+	if attempts <= 0 {
+		attempts = DEFAULT_AUTH_RETRIES
+	}
+	delay := authRetryDelay
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var transient *transientAuthError
+		if err = step(); !errors.As(err, &transient) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt < attempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+	}
+	return fmt.Errorf("%w after %d attempts: %v", ErrAuthServerUnreachable, attempts, err)
+}
+
+// httpClient returns the HTTP client used for the auth flow, falling back to http.DefaultClient.
+func (a *Auth) httpClient() *http.Client {
+	if a.httpx == nil {
+		return http.DefaultClient
+	}
+	return a.httpx
+}
+
+// applyHeaders sets the browser headers configured on the Client on the given auth request, if any.
+func (a *Auth) applyHeaders(req *http.Request) {
+	if a.setHeaders != nil {
+		a.setHeaders(req)
+	}
+}
+
+// authBaseUrl returns the base URL of the token proxy, falling back to the default one.
+func (a *Auth) authBaseUrl() string {
+	if a.baseUrl == "" {
+		return DEFAULT_AUTH_BASE_URL
+	}
+	return strings.TrimSuffix(a.baseUrl, "/")
+}
+
+// stepOneDirect builds the auth0 authorize URL for the direct auth method, generating a new PKCE code verifier.
+func (a *Auth) stepOneDirect(_ context.Context) (string, error) {
+	// Generate a random code verifier and derive the S256 code challenge from it
+	verifier := make([]byte, 32)
+	if _, err := rand.Read(verifier); err != nil {
+		return "", err
+	}
+	a.codeVerifier = base64.RawURLEncoding.EncodeToString(verifier)
+	challenge := sha256.Sum256([]byte(a.codeVerifier))
+
+	query := url.Values{
+		"client_id":             {auth0ClientID},
+		"audience":              {"https://api.openai.com/v1"},
+		"redirect_uri":          {auth0RedirectUri},
+		"scope":                 {"openid email profile offline_access model.request model.read organization.read"},
+		"response_type":         {"code"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"prompt":                {"login"},
+	}
+	return AUTH0_URL + "/authorize?" + query.Encode(), nil
+}
+
+// stepThreeDirect exchanges the authorization code from the callback URL for an access token at auth0.
+func (a *Auth) stepThreeDirect(ctx context.Context, callback_url string) (*authResp, error) {
+	// Extract the authorization code from the callback URL
+	parsed, err := url.Parse(callback_url)
+	if err != nil {
+		return nil, err
+	}
+	code := parsed.Query().Get("code")
+	if code == "" {
+		return nil, fmt.Errorf("no authorization code found in callback url: %s", callback_url)
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"grant_type":    "authorization_code",
+		"client_id":     auth0ClientID,
+		"redirect_uri":  auth0RedirectUri,
+		"code":          code,
+		"code_verifier": a.codeVerifier,
+	})
+
+	endpoint := AUTH0_URL + "/oauth/token"
+	req, _ := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(string(payload)))
+	a.applyHeaders(req)
+	req.Header.Set("content-type", "application/json")
+
+	resp, err := a.httpClient().Do(req)
+	if err != nil {
+		return nil, &transientAuthError{fmt.Errorf("auth endpoint %s is unreachable: %w", endpoint, err)}
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response from auth endpoint %s (%s): %w", endpoint, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ChatError{Message: "auth endpoint " + endpoint + " failed: " + result.ErrorDescription, Code: resp.StatusCode}
+	}
+
+	return &authResp{
+		AccessToken: result.AccessToken,
+		Expires:     time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}, nil
+}
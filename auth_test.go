@@ -0,0 +1,87 @@
+package chatgpt_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/amarnathcjd/chatgpt"
+)
+
+// authProxy is an HTTP proxy serving a fake login flow for the token proxy and auth0 hosts,
+// which records the URLs it proxies and their Proxy-Authorization headers.
+type authProxy struct {
+	mu        sync.Mutex
+	urls      []string
+	proxyAuth []string
+}
+
+func (p *authProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.urls = append(p.urls, r.Method+" "+r.URL.String())
+	p.proxyAuth = append(p.proxyAuth, r.Header.Get("Proxy-Authorization"))
+	p.mu.Unlock()
+	io.Copy(io.Discard, r.Body)
+
+	redirect := func(location string) {
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusFound)
+	}
+	switch r.URL.Host + " " + r.Method + " " + r.URL.Path {
+	case "auth.example.invalid GET /auth/endpoint":
+		io.WriteString(w, `{"state": "proxy-state", "url": "http://auth0.example.invalid/authorize?client=test"}`)
+	case "auth0.example.invalid GET /authorize":
+		redirect("/u/login/identifier?state=login-state")
+	case "auth0.example.invalid POST /u/login/identifier":
+		redirect("/u/login/password?state=login-state")
+	case "auth0.example.invalid POST /u/login/password":
+		redirect("/authorize/resume?state=login-state")
+	case "auth0.example.invalid GET /authorize/resume":
+		redirect("com.openai.chat://auth0.openai.com/callback?code=code")
+	case "auth.example.invalid POST /auth/token":
+		io.WriteString(w, `{"accessToken": "proxied-token", "expires": "2030-01-01T00:00:00Z"}`)
+	default:
+		if r.Method == http.MethodHead {
+			return // the proxy check of Start
+		}
+		http.NotFound(w, r)
+	}
+}
+
+func TestLoginThroughProxy(t *testing.T) {
+	defer func(authUrl string) { chatgpt.AUTH0_URL = authUrl }(chatgpt.AUTH0_URL)
+	chatgpt.AUTH0_URL = "http://auth0.example.invalid"
+	handler := &authProxy{}
+	proxyServer := httptest.NewServer(handler)
+	defer proxyServer.Close()
+	proxyUrl, _ := url.Parse(proxyServer.URL)
+	proxyUrl.User = url.UserPassword("user", "secret")
+
+	client := startClient(t, chatgpt.Config{
+		Email:           "me@example.com",
+		Password:        "password",
+		AuthBaseURL:     "http://auth.example.invalid",
+		BaseURL:         "http://backend.example.invalid/conversation",
+		Proxy:           proxyUrl,
+		ProxyCheckURL:   "http://auth.example.invalid",
+		ValidateOnStart: new(bool),
+	})
+	if token := client.GetAccessToken(); token != "proxied-token" {
+		t.Errorf("got access token %q", token)
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	// The proxy check, the token proxy endpoint, the four auth0 steps and the token exchange
+	if len(handler.urls) != 7 {
+		t.Errorf("got %d requests through the proxy: %q", len(handler.urls), handler.urls)
+	}
+	for i, auth := range handler.proxyAuth {
+		if auth == "" {
+			t.Errorf("request %s was sent without the proxy credentials", handler.urls[i])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// CustomInstructions represents the custom instructions of an account, as shown in the web UI's "Custom instructions" panel.
+type CustomInstructions struct {
+	// What the model should know about the user.
+	AboutUser string `json:"about_user_message"`
+	// How the model should respond.
+	AboutModel string `json:"about_model_message"`
+	// Whether or not the custom instructions are applied to new conversations.
+	Enabled bool `json:"enabled"`
+}
+
+// GetCustomInstructions returns the custom instructions of the account, only supported in access token mode.
+func (c *Client) GetCustomInstructions(ctx context.Context) (*CustomInstructions, error) {
+	if err := c.checkAccessTokenMode("custom instructions"); err != nil {
+		return nil, err
+	}
+	var instructions CustomInstructions
+	if err := c.backendRequest(ctx, "GET", "user_system_messages", nil, &instructions); err != nil {
+		return nil, err
+	}
+	return &instructions, nil
+}
+
+// SetCustomInstructions sets the custom instructions of the account, only supported in access token mode.
+func (c *Client) SetCustomInstructions(ctx context.Context, aboutUser, aboutModel string, enabled bool) error {
+	if err := c.checkAccessTokenMode("custom instructions"); err != nil {
+		return err
+	}
+	return c.backendRequest(ctx, "POST", "user_system_messages", &CustomInstructions{
+		AboutUser:  aboutUser,
+		AboutModel: aboutModel,
+		Enabled:    enabled,
+	}, nil)
+}
+
+// checkAccessTokenMode returns an error if the client is not started or not in access token mode.
+func (c *Client) checkAccessTokenMode(feature string) error {
+	if !c.auth.clientStarted.Load() {
+		return fmt.Errorf("client is not started, call Start() first")
+	}
+	if c.authmode != AccessTokenMode {
+		return fmt.Errorf("%s: %w", feature, ErrRequiresAccessToken)
+	}
+	return nil
+}
+
+// checkApiKeyMode returns an error if the client is not started or not in API key mode.
+func (c *Client) checkApiKeyMode(feature string) error {
+	if !c.auth.clientStarted.Load() {
+		return fmt.Errorf("client is not started, call Start() first")
+	}
+	if c.authmode != ApiKeyMode {
+		return fmt.Errorf("%s: %w", feature, ErrRequiresAPIKey)
+	}
+	return nil
+}
+
+// backendRequest sends a JSON request to the given backend endpoint using the access token.
+// The request body is omitted if body is nil, and the response body is decoded into out if it is not nil.
+func (c *Client) backendRequest(ctx context.Context, method, endpoint string, body interface{}, out interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var payload io.Reader
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+		payload = strings.NewReader(string(data))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.backendUrl(endpoint), payload)
+	if err != nil {
+		return fmt.Errorf("system error: %w", err)
+	}
+	c.setHeaders(req, c.auth.accessToken)
+	c.setBrowserHeaders(req)
+	c.logRequest(req, string(data))
+
+	resp, err := c.httpx.Do(req)
+	if err != nil {
+		return fmt.Errorf("system error: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("system error: %w", err)
+	}
+	c.logResponse(resp, string(respBody))
+
+	// If the backend returned an error, return a ChatError containing the error message and HTTP status code
+	if resp.StatusCode != http.StatusOK {
+		return &ChatError{Message: string(respBody), Code: resp.StatusCode, RetryAfter: retryAfter(resp.Header)}
+	}
+	if out != nil {
+		return json.Unmarshal(respBody, out)
+	}
+	return nil
+}
+
+// FeedbackRating is the rating of an assistant message sent with SendFeedback.
+type FeedbackRating string
+
+const (
+	// ThumbsUp rates a message as good.
+	ThumbsUp FeedbackRating = "thumbsUp"
+	// ThumbsDown rates a message as bad.
+	ThumbsDown FeedbackRating = "thumbsDown"
+)
+
+// SendFeedback rates an assistant message, optionally with a text explaining the rating, only supported in access token mode.
+// messageID is the ChatResponse.MessageID of the rated message.
+func (c *Client) SendFeedback(ctx context.Context, conversationID, messageID string, rating FeedbackRating, text string) error {
+	if err := c.checkAccessTokenMode("feedbacks"); err != nil {
+		return err
+	}
+	if rating != ThumbsUp && rating != ThumbsDown {
+		return fmt.Errorf("unknown feedback rating: %s", rating)
+	}
+	payload := map[string]interface{}{
+		"conversation_id": conversationID,
+		"message_id":      messageID,
+		"rating":          rating,
+	}
+	if text != "" {
+		payload["text"] = text
+	}
+	return c.backendRequest(ctx, "POST", "conversation/message_feedback", payload, nil)
+}
@@ -0,0 +1,137 @@
+package chatgpt
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cache stores responses keyed by prompt and conversation state, see Config.ResponseCache.
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns the value stored for the key, and whether it was found and not expired.
+	Get(key string) (string, bool)
+	// Set stores the value for the key, expiring after ttl (never if ttl is zero).
+	Set(key, value string, ttl time.Duration)
+}
+
+// cacheTailMessages is the number of most recent messages, including the prompt, used in cache keys.
+const cacheTailMessages = 6
+
+// MemoryCache is an in-memory LRU implementation of Cache.
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // Most recently used entries at the front.
+	hits     int
+	misses   int
+}
+
+// memoryCacheEntry is an entry of MemoryCache.
+type memoryCacheEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// NewMemoryCache creates a new MemoryCache holding up to capacity entries, evicting the least recently used ones.
+func NewMemoryCache(capacity int) *MemoryCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value stored for the key, and whether it was found and not expired.
+func (m *MemoryCache) Get(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.entries[key]
+	if !ok {
+		m.misses++
+		return "", false
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.order.Remove(element)
+		delete(m.entries, key)
+		m.misses++
+		return "", false
+	}
+	m.order.MoveToFront(element)
+	m.hits++
+	return entry.value, true
+}
+
+// Set stores the value for the key, expiring after ttl (never if ttl is zero).
+func (m *MemoryCache) Set(key, value string, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	if element, ok := m.entries[key]; ok {
+		element.Value = &memoryCacheEntry{key: key, value: value, expires: expires}
+		m.order.MoveToFront(element)
+		return
+	}
+	m.entries[key] = m.order.PushFront(&memoryCacheEntry{key: key, value: value, expires: expires})
+	if m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Stats returns the number of cache hits and misses so far.
+func (m *MemoryCache) Stats() (hits, misses int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hits, m.misses
+}
+
+// responseCacheKey returns the cache key for the given engine and conversation, made of its system message and
+// most recent messages (ending with the prompt), and of the parameters of the request changing the response:
+// the temperature, the stop sequences, the logit bias and the client's extra parameters.
+func (c *Client) responseCacheKey(engine string, conversation Conversation, askOpts ...AskOpts) string {
+	hash := sha256.New()
+	hash.Write([]byte(engine + "\x00" + strconv.FormatFloat(c.temperature, 'f', -1, 64) + "\x00" + conversation.InitMessage + "\x00"))
+	stop, _ := c.stopSequences(askOpts...)
+	params, _ := json.Marshal(map[string]interface{}{"stop": stop, "logit_bias": c.logitBias(askOpts...), "extra": c.extraParams})
+	hash.Write(append(params, 0))
+	tail := conversation.Messages
+	if len(tail) > cacheTailMessages {
+		tail = tail[len(tail)-cacheTailMessages:]
+	}
+	for _, message := range tail {
+		hash.Write([]byte(message.Role + "\x00" + message.Name + "\x00" + message.Content + "\x00"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// cacheable returns true if responses can be cached with the client's settings and the given askOpts.
+func (c *Client) cacheable(askOpts ...AskOpts) bool {
+	if c.cache == nil || c.temperature > c.cacheMaxTemp {
+		return false
+	}
+	// A cached response only holds the first choice
+	n := c.n
+	if len(askOpts) > 0 && askOpts[0].N > 0 {
+		n = askOpts[0].N
+	}
+	if n > 1 {
+		return false
+	}
+	// Per-request extra parameters, seeds and response schemas are not part of the cache key
+	return len(askOpts) == 0 || (!askOpts[0].NoCache && len(askOpts[0].ExtraParams) == 0 && askOpts[0].Seed == nil && len(askOpts[0].ResponseSchema) == 0)
+}
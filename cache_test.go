@@ -0,0 +1,89 @@
+package chatgpt_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+func TestResponseCacheKeys(t *testing.T) {
+	server := chatgpttest.NewServer()
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{ResponseCache: chatgpt.NewMemoryCache(10), Temperature: 0.5})
+
+	ask := func(name string, opts chatgpt.AskOpts, wantCached bool) {
+		t.Helper()
+		requests := len(server.Requests())
+		if !wantCached {
+			server.AddResponses(chatgpttest.Response{Message: name})
+		}
+		response, err := client.Ask(context.Background(), "What is 2+2?", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if response.Cached != wantCached {
+			t.Errorf("%s: got cached %t, want %t", name, response.Cached, wantCached)
+		}
+		if sent := len(server.Requests()) > requests; sent == wantCached {
+			t.Errorf("%s: got request sent %t", name, sent)
+		}
+	}
+
+	// The same prompt in a new conversation is served from the cache
+	ask("first", chatgpt.AskOpts{}, false)
+	ask("same", chatgpt.AskOpts{}, true)
+	// Stop sequences and logit biases change the response, they are part of the key
+	ask("stop", chatgpt.AskOpts{Stop: []string{"\n"}}, false)
+	ask("stop again", chatgpt.AskOpts{Stop: []string{"\n"}}, true)
+	ask("bias", chatgpt.AskOpts{LogitBias: map[string]float64{"19": -100}}, false)
+	ask("other bias", chatgpt.AskOpts{LogitBias: map[string]float64{"19": 100}}, false)
+	ask("bias again", chatgpt.AskOpts{LogitBias: map[string]float64{"19": -100}}, true)
+	// Requests bypassing the cache or with a seed are always sent
+	ask("no cache", chatgpt.AskOpts{NoCache: true}, false)
+	seed := 42
+	ask("seed", chatgpt.AskOpts{Seed: &seed}, false)
+}
+
+func TestResponseCacheSkipsSeveralChoices(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "4"}, chatgpttest.Response{Message: "four"})
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{ResponseCache: chatgpt.NewMemoryCache(10), Temperature: 0.5})
+
+	if _, err := client.Ask(context.Background(), "What is 2+2?"); err != nil {
+		t.Fatal(err)
+	}
+	// The cached answer only has one choice, AskN is sent anyway
+	choices, err := client.AskN(context.Background(), "What is 2+2?", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(choices) != 3 || choices[0] != "four" {
+		t.Errorf("got choices %q, want 3 fresh ones", choices)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	cache := chatgpt.NewMemoryCache(2)
+	cache.Set("a", "1", 0)
+	cache.Set("b", "2", 0)
+	cache.Get("a")
+	cache.Set("c", "3", 0) // evicts b, the least recently used
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if value, ok := cache.Get("a"); !ok || value != "1" {
+		t.Errorf("got %q, %t for a", value, ok)
+	}
+
+	cache.Set("d", "4", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("d"); ok {
+		t.Error("d should have expired")
+	}
+	if hits, misses := cache.Stats(); hits != 2 || misses != 2 {
+		t.Errorf("got %d hits and %d misses, want 2 and 2", hits, misses)
+	}
+}
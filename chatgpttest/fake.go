@@ -0,0 +1,164 @@
+// Package chatgpttest provides a configurable fake of the chatgpt client for downstream tests.
+package chatgpttest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+)
+
+// ErrNoResponse is returned when the fake has no scripted response left.
+var ErrNoResponse = errors.New("chatgpttest: no scripted response left")
+
+// Response is a scripted response returned by Fake, in the order they were added.
+type Response struct {
+	// The message returned by Ask, and the final message of AskStream.
+	Message string
+	// The chunks streamed by AskStream, defaults to the words of Message.
+	// Like the real client, each streamed ChatResponse carries the text received so far.
+	Chunks []string
+	// The conversation ID of the response, defaults to the one from AskOpts or "default".
+	ConversationID string
+	// The error returned by Ask and AskStream instead of the response.
+	Err error
+	// The HTTP status code of the error response of Server when Err is set, defaults to 500.
+	Status int
+	// The error delivered through ChatResponse.Err after the chunks have been streamed.
+	StreamErr error
+}
+
+// Fake is a scripted implementation of chatgpt.Chatter that records the prompts it receives.
+// It is safe for concurrent use.
+type Fake struct {
+	// The error returned by Start.
+	StartErr error
+	// The delay before each streamed chunk.
+	ChunkDelay time.Duration
+
+	mu        sync.Mutex
+	responses []Response
+	prompts   []string
+	started   bool
+}
+
+// Ensure that Fake implements chatgpt.Chatter.
+var _ chatgpt.Chatter = (*Fake)(nil)
+
+// NewFake creates a new Fake returning the given responses in order.
+func NewFake(responses ...Response) *Fake {
+	return &Fake{responses: responses}
+}
+
+// AddResponses appends scripted responses.
+func (f *Fake) AddResponses(responses ...Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, responses...)
+}
+
+// Prompts returns the prompts received so far, in order.
+func (f *Fake) Prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.prompts...)
+}
+
+// Started returns true if Start was called successfully.
+func (f *Fake) Started() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.started
+}
+
+// Start marks the fake as started, or returns StartErr.
+func (f *Fake) Start() error {
+	if f.StartErr != nil {
+		return f.StartErr
+	}
+	f.mu.Lock()
+	f.started = true
+	f.mu.Unlock()
+	return nil
+}
+
+// Ask records the prompt and returns the next scripted response.
+func (f *Fake) Ask(ctx context.Context, prompt string, askOpts ...chatgpt.AskOpts) (*chatgpt.ChatResponse, error) {
+	response, err := f.next(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &chatgpt.ChatResponse{
+		Message:        response.Message,
+		ConversationID: conversationID(response, askOpts...),
+	}, nil
+}
+
+// AskStream records the prompt and streams the chunks of the next scripted response, waiting ChunkDelay before each one.
+func (f *Fake) AskStream(ctx context.Context, prompt string, askOpts ...chatgpt.AskOpts) (chan *chatgpt.ChatResponse, error) {
+	response, err := f.next(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := response.Chunks
+	if chunks == nil {
+		chunks = strings.SplitAfter(response.Message, " ")
+	}
+	id := conversationID(response, askOpts...)
+
+	ch := make(chan *chatgpt.ChatResponse, len(chunks)+1)
+	go func() {
+		defer close(ch)
+		var text strings.Builder
+		for _, chunk := range chunks {
+			if f.ChunkDelay > 0 {
+				select {
+				case <-time.After(f.ChunkDelay):
+				case <-ctx.Done():
+					ch <- &chatgpt.ChatResponse{Err: ctx.Err()}
+					return
+				}
+			}
+			text.WriteString(chunk)
+			ch <- &chatgpt.ChatResponse{
+				Message:        strings.TrimSpace(text.String()),
+				ConversationID: id,
+			}
+		}
+		if response.StreamErr != nil {
+			ch <- &chatgpt.ChatResponse{Err: response.StreamErr}
+		}
+	}()
+	return ch, nil
+}
+
+// next records the prompt and pops the next scripted response.
+func (f *Fake) next(prompt string) (Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prompts = append(f.prompts, prompt)
+	if len(f.responses) == 0 {
+		return Response{}, ErrNoResponse
+	}
+	response := f.responses[0]
+	f.responses = f.responses[1:]
+	return response, response.Err
+}
+
+// conversationID returns the conversation ID of the response, falling back to the one from askOpts or "default".
+func conversationID(response Response, askOpts ...chatgpt.AskOpts) string {
+	if response.ConversationID != "" {
+		return response.ConversationID
+	}
+	if len(askOpts) > 0 && askOpts[0].ConversationID != "" {
+		return askOpts[0].ConversationID
+	}
+	return "default"
+}
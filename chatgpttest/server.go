@@ -0,0 +1,248 @@
+package chatgpttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake of the OpenAI chat completions API and of the access token conversation backend, to test code
+// using a real chatgpt.Client. It answers with the scripted responses in the order they were added, and records the
+// requests it receives. It is safe for concurrent use.
+//
+// Point the client at it with chatgpt.OPENAI_HOST = server.APIURL() in API key mode, or Config.BaseURL set to
+// server.ConversationURL() in access token mode, along with Config.ProxyCheckURL set to server.URL when a proxy is used.
+type Server struct {
+	*httptest.Server
+
+	// The delay before each streamed chunk of the conversation endpoint.
+	ChunkDelay time.Duration
+	// The status code of the models endpoint used to validate access tokens, defaults to 200.
+	ModelsStatus int
+
+	mu        sync.Mutex
+	responses []Response
+	requests  []Request
+	count     int
+}
+
+// Request is a request received by Server.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	// The decoded JSON body, nil if the request has none.
+	Body map[string]interface{}
+}
+
+// Messages returns the messages of a chat completions request body, as role and content pairs.
+func (r Request) Messages() [][2]string {
+	messages, _ := r.Body["messages"].([]interface{})
+	pairs := make([][2]string, 0, len(messages))
+	for _, m := range messages {
+		message, _ := m.(map[string]interface{})
+		role, _ := message["role"].(string)
+		content, _ := message["content"].(string)
+		pairs = append(pairs, [2]string{role, content})
+	}
+	return pairs
+}
+
+// NewServer starts a new Server answering with the given responses in order. Close it once done.
+//
+// The fields of Response are used as follows: Message is the reply, Chunks are streamed by the conversation endpoint
+// (the words of Message by default), ConversationID is the conversation of access token replies, Err is returned as an
+// error response with Status (500 by default), and StreamErr aborts the stream of the conversation endpoint after the chunks.
+func NewServer(responses ...Response) *Server {
+	s := &Server{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// APIURL returns the URL of the fake chat completions endpoint, to be set as chatgpt.OPENAI_HOST.
+func (s *Server) APIURL() string {
+	return s.URL + "/v1/chat/completions"
+}
+
+// ConversationURL returns the URL of the fake conversation endpoint, to be set as Config.BaseURL.
+func (s *Server) ConversationURL() string {
+	return s.URL + "/conversation"
+}
+
+// AddResponses appends scripted responses.
+func (s *Server) AddResponses(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, responses...)
+}
+
+// Requests returns the chat completions and conversation requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// serveHTTP routes the requests to the fake endpoints.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodHead:
+		// The proxy check of Start
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && r.URL.Path == "/models":
+		status := s.ModelsStatus
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, `{"models":[]}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/chat/completions":
+		request, seq := s.record(r)
+		s.serveCompletion(w, request, seq)
+	case r.Method == http.MethodPost && r.URL.Path == "/conversation":
+		request, seq := s.record(r)
+		s.serveConversation(w, request, seq)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// record records the request and returns it along with its sequence number.
+func (s *Server) record(r *http.Request) (Request, int) {
+	request := Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone()}
+	if data, err := io.ReadAll(r.Body); err == nil && len(data) > 0 {
+		json.Unmarshal(data, &request.Body)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, request)
+	s.count++
+	return request, s.count
+}
+
+// next pops the next scripted response.
+func (s *Server) next() (Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.responses) == 0 {
+		return Response{}, false
+	}
+	response := s.responses[0]
+	s.responses = s.responses[1:]
+	return response, true
+}
+
+// writeError writes the error of a response, or ErrNoResponse if there is no response left, in the OpenAI format.
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  map[string]string{"message": err.Error(), "type": "chatgpttest"},
+		"detail": err.Error(),
+	})
+}
+
+// serveCompletion answers a chat completions request, with as many choices as requested with n.
+func (s *Server) serveCompletion(w http.ResponseWriter, request Request, seq int) {
+	w.Header().Set("x-request-id", fmt.Sprintf("req_%d", seq))
+	response, ok := s.next()
+	if !ok {
+		writeError(w, 0, ErrNoResponse)
+		return
+	}
+	if response.Err != nil {
+		writeError(w, response.Status, response.Err)
+		return
+	}
+
+	n := 1
+	if value, ok := request.Body["n"].(float64); ok && value > 1 {
+		n = int(value)
+	}
+	choices := make([]map[string]interface{}, n)
+	for i := range choices {
+		choices[i] = map[string]interface{}{
+			"index":         i,
+			"message":       map[string]string{"role": "assistant", "content": response.Message},
+			"finish_reason": "stop",
+		}
+	}
+	model, _ := request.Body["model"].(string)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      fmt.Sprintf("chatcmpl-%d", seq),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": choices,
+		// Like the API, which identifies the backend configuration serving the model
+		"system_fingerprint": "fp_chatgpttest",
+		"usage": map[string]int{
+			"prompt_tokens":     len(request.Messages()),
+			"completion_tokens": len(strings.Fields(response.Message)),
+			"total_tokens":      len(request.Messages()) + len(strings.Fields(response.Message)),
+		},
+	})
+}
+
+// serveConversation answers a conversation request with a stream of server-sent events, each carrying
+// the text received so far like the backend does.
+func (s *Server) serveConversation(w http.ResponseWriter, request Request, seq int) {
+	response, ok := s.next()
+	if !ok {
+		writeError(w, 0, ErrNoResponse)
+		return
+	}
+	if response.Err != nil {
+		writeError(w, response.Status, response.Err)
+		return
+	}
+
+	conversationID := response.ConversationID
+	if conversationID == "" {
+		conversationID, _ = request.Body["conversation_id"].(string)
+	}
+	if conversationID == "" {
+		conversationID = fmt.Sprintf("conversation-%d", seq)
+	}
+	chunks := response.Chunks
+	if chunks == nil {
+		chunks = strings.SplitAfter(response.Message, " ")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	io.WriteString(w, "\n") // the first line is checked for errors by the client
+	var text strings.Builder
+	for i, chunk := range chunks {
+		if s.ChunkDelay > 0 {
+			time.Sleep(s.ChunkDelay)
+		}
+		text.WriteString(chunk)
+		message := map[string]interface{}{
+			"id":      fmt.Sprintf("message-%d", seq),
+			"author":  map[string]string{"role": "assistant"},
+			"content": map[string]interface{}{"content_type": "text", "parts": []string{text.String()}},
+		}
+		if i == len(chunks)-1 && response.StreamErr == nil {
+			message["metadata"] = map[string]interface{}{"finish_details": map[string]string{"type": "stop"}}
+		}
+		data, _ := json.Marshal(map[string]interface{}{"message": message, "conversation_id": conversationID})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if response.StreamErr != nil {
+		panic(http.ErrAbortHandler) // cut the connection without ending the stream
+	}
+	io.WriteString(w, "data: [DONE]\n\n")
+}
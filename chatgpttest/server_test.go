@@ -0,0 +1,81 @@
+package chatgpttest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+func TestServerApiKeyMode(t *testing.T) {
+	server := chatgpttest.NewServer(
+		chatgpttest.Response{Message: "Hello there"},
+		chatgpttest.Response{Err: errors.New("slow down"), Status: http.StatusTooManyRequests},
+	)
+	defer server.Close()
+	defer func(host string) { chatgpt.OPENAI_HOST = host }(chatgpt.OPENAI_HOST)
+	chatgpt.OPENAI_HOST = server.APIURL()
+
+	client := chatgpt.NewClient(&chatgpt.Config{ApiKey: "sk-test", DisableCache: true, LogLevel: chatgpt.LogLevelError})
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Ask(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Message != "Hello there" || response.RequestID != "req_1" {
+		t.Errorf("got message %q and request ID %q", response.Message, response.RequestID)
+	}
+
+	_, err = client.Ask(context.Background(), "Hi again")
+	var chatErr *chatgpt.ChatError
+	if !errors.As(err, &chatErr) || chatErr.Code != http.StatusTooManyRequests || chatErr.Message != "slow down" {
+		t.Errorf("got error %v, want the scripted 429", err)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	if got := requests[0].Header.Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("got Authorization %q", got)
+	}
+	if messages := requests[0].Messages(); len(messages) != 2 || messages[1] != [2]string{"user", "Hi"} {
+		t.Errorf("got messages %q", messages)
+	}
+}
+
+func TestServerAccessTokenMode(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Hello from the backend", ConversationID: "c1"})
+	defer server.Close()
+
+	client := chatgpt.NewClient(&chatgpt.Config{
+		AccessToken:  "token",
+		BaseURL:      server.ConversationURL(),
+		DisableCache: true,
+		LogLevel:     chatgpt.LogLevelError,
+	})
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := client.AskStream(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last *chatgpt.ChatResponse
+	count := 0
+	for message := range ch {
+		if message.Err != nil {
+			t.Fatal(message.Err)
+		}
+		last = message
+		count++
+	}
+	if count != 4 || last.Message != "Hello from the backend" || last.ConversationID != "c1" {
+		t.Errorf("got %d messages, the last one %+v", count, last)
+	}
+}
@@ -1,10 +1,16 @@
 package chatgpt
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 )
 
 const (
@@ -12,41 +18,219 @@ const (
 	AccessTokenMode        // Set a value of 1 to AccessTokenMode. This indicates that the user of the program has set up access token properly.
 )
 
+// The values of Config.AuthMode.
+const (
+	AuthModeAuto        = "auto"         // Pick the auth mode from the credentials provided, see checkCredentials for the precedence.
+	AuthModeApiKey      = "api_key"      // Force ApiKeyMode, Start fails if no API key is set.
+	AuthModeAccessToken = "access_token" // Force AccessTokenMode, Start fails if neither an access token nor email and password are set.
+)
+
+// The URL requested through the proxy server on Start to check that it works.
+var DEFAULT_PROXY_CHECK_URL = "https://api.openai.com"
+
+// proxyCheckTimeout is the timeout of the proxy check on Start.
+const proxyCheckTimeout = 10 * time.Second
+
+// ErrProxyAuthRequired is returned by Start when the proxy server requires credentials, which can be set in the proxy URL's user info.
+var ErrProxyAuthRequired = errors.New("proxy server requires authentication (407), set the username and password in the proxy URL")
+
+// ErrUnsupportedInMode is returned by methods that are not supported in the client's auth mode.
+var ErrUnsupportedInMode = errors.New("not supported in this auth mode")
+
+// ErrRequiresAPIKey and ErrRequiresAccessToken are returned by the methods only supported in API key mode
+// and access token mode respectively. Both also match ErrUnsupportedInMode with errors.Is.
+var (
+	ErrRequiresAPIKey      error = modeError("only supported in API key mode")
+	ErrRequiresAccessToken error = modeError("only supported in access token mode")
+)
+
+// modeError is an auth mode mismatch error, it matches ErrUnsupportedInMode.
+type modeError string
+
+func (e modeError) Error() string { return string(e) }
+
+func (e modeError) Is(target error) bool { return target == ErrUnsupportedInMode }
+
 // Client represents a connection to the OpenAI API.
 // It contains the client's API key, access token, HTTP client, conversation history, settings, and stream details.
 type Client struct {
-	auth           *Auth                   // The authentication object used for authenticating with OpenAI.
-	httpx          *http.Client            // The HTTP client used for sending requests to OpenAI.
-	conversations  map[string]Conversation // A map of conversation IDs to Conversation objects.
-	temperature    float64                 // The sampling temperature for generating text.
-	engine         string                  // The name of the GPT model being used by this client.
-	initMessage    string                  // The initial message sent to start a new conversation.
-	baseUrl        string                  // Custom base URL for the API.
-	enableInternet bool                    // Whether or not to allow the use of external websites in responses.
-	stream         bool                    // Whether or not to stream response messages as they come in.
-	proxy          *url.URL                // The URL of the proxy server to use for requests.
-	authmode       int                     // The authentication mode used by this client.
-	ispaid         bool                    // Whether or not the account is a paid account.
-	logger         *Logger                 // The logger used for logging messages.
+	auth            *Auth                      // The authentication object used for authenticating with OpenAI.
+	httpx           *http.Client               // The HTTP client used for sending requests to OpenAI.
+	conversations   map[string]Conversation    // A map of conversation IDs to Conversation objects.
+	mu              sync.RWMutex               // Guards conversations.
+	temperature     float64                    // The sampling temperature for generating text.
+	engine          string                     // The name of the GPT model being used by this client.
+	initMessage     string                     // The initial message sent to start a new conversation.
+	baseUrl         string                     // Custom base URL for the API.
+	enableInternet  bool                       // Whether or not to allow the use of external websites in responses.
+	stream          bool                       // Whether or not Ask reads the responses as streams, see Config.Stream.
+	proxy           *url.URL                   // The URL of the proxy server to use for requests, http, https or socks5.
+	authmode        int                        // The authentication mode used by this client.
+	forceMode       string                     // The authentication mode forced by the configuration, see Config.AuthMode.
+	extraParams     map[string]interface{}     // Additional parameters merged into each request payload.
+	anyEngine       bool                       // Whether or not to skip warning about unknown engines.
+	proxyCheck      string                     // The URL requested through the proxy on Start, see Config.ProxyCheckURL.
+	transport       *switchTransport           // The base transport of httpx, swapped by SetProxy.
+	tuning          *TransportConfig           // The connection tuning knobs of the transport built by the client.
+	ispaid          bool                       // Whether or not the account is a paid account.
+	logger          *Logger                    // The logger used for logging messages.
+	validate        bool                       // Whether or not to validate the access token when starting the client.
+	autoContinue    int                        // The maximum number of times to continue a cut off response.
+	userAgent       string                     // The User-Agent header, defaults to a browser one for access token and auth requests.
+	extraHeaders    map[string]string          // Additional headers sent with access token and auth requests.
+	headers         map[string]string          // Headers sent with every outbound request, see Config.Headers.
+	streams         map[int]context.CancelFunc // The cancel functions of the in-flight streams, keyed by stream ID.
+	streamsMu       sync.Mutex                 // Guards streams, nextStreamID, requests and nextRequestID.
+	nextStreamID    int                        // The ID assigned to the next tracked stream.
+	requests        map[string]cancelFuncs     // The cancel functions of the in-flight requests, by conversation ID, see CancelConversation.
+	nextRequestID   int                        // The ID assigned to the next tracked request.
+	requestTimeout  time.Duration              // The timeout applied to each request, zero means no timeout.
+	noInitMessage   bool                       // Whether or not to skip sending the init message in access token mode.
+	arkose          ArkoseProvider             // The provider of Arkose tokens for gpt-4 family models in access token mode.
+	n               int                        // The number of completions to generate for each request.
+	bias            map[string]float64         // The biases of token IDs applied to each request.
+	stop            []string                   // The sequences where the generation stops.
+	cache           Cache                      // The cache of responses, nil if disabled.
+	cacheTTL        time.Duration              // The time to live of cached responses.
+	cacheMaxTemp    float64                    // The highest temperature at which responses are cached.
+	strict          bool                       // Whether or not asking with an unknown conversation ID is an error.
+	defaultConv     string                     // The conversation of the questions without a conversation ID, a new one each time if empty.
+	stateless       bool                       // Whether or not the new conversations of questions without a conversation ID are kept.
+	maxGenerated    int                        // The maximum number of conversations with a generated ID kept in memory, negative for no limit.
+	generated       map[string]*list.Element   // The conversations with a generated ID kept in memory, guarded by mu.
+	generatedOrder  *list.List                 // The IDs of generated, most recently asked first, guarded by mu.
+	strictTemplates bool                       // Whether or not rendering a template fails on missing variables.
+	templates       *template.Template         // The registered prompt templates, nil until one is registered.
+	templatesMu     sync.RWMutex               // Guards templates.
+	compression     string                     // How conversations reaching the token limit are shortened.
+	compressAt      float64                    // The fraction of the token limit at which conversations are summarized.
+	compressRuns    int                        // The maximum number of summaries per conversation.
+	reserve         int                        // The number of tokens reserved for the response, see Config.ResponseReserve.
+	serverOnly      bool                       // Whether or not access token mode conversations only track their parent ID.
+	rateWarnAt      float64                    // The fraction of the rate limits below which a warning is logged.
+	ratePacing      bool                       // Whether or not requests wait for exhausted rate limits to reset.
+	rateLimits      RateLimits                 // The rate limits of the last API key mode response.
+	rateMu          sync.Mutex                 // Guards rateLimits.
+	onCreated       ConversationHook           // Called when Ask creates a conversation.
+	allowEmpty      bool                       // Whether or not empty prompts are allowed.
+	redactReq       func(string) string        // Applied to prompts before they leave the client.
+	redactResp      func(string) string        // Applied to responses before they are stored, logged or returned.
+	convStore       ConversationStore          // The durable storage of conversations, nil if disabled.
+	handlers        []func(Event)              // The event handlers registered with OnEvent.
+	events          chan Event                 // The queue of events for the handlers, nil until one is registered.
+	eventsMu        sync.RWMutex               // Guards handlers and events.
+	autoRecreate    bool                       // Whether or not to recreate conversations the backend lost, see ErrRemoteConversationGone.
+	replyLang       string                     // The language of the replies, see Config.ReplyLanguage.
+	settingsMu      sync.RWMutex               // Guards the settings changed at runtime: engine, enableInternet, stream, userAgent, extraHeaders and headers.
+	startMu         sync.Mutex                 // Serializes Start and Close, the only writers of auth.clientStarted.
+	idleTimeout     time.Duration              // The time without data after which a stream is stalled, see Config.StreamIdleTimeout.
+	streamBuffer    int                        // The capacity of the stream channels, see Config.StreamBufferSize.
+	slowConsumer    time.Duration              // The time a stream waits for room in its channel, see Config.SlowConsumerTimeout.
+	convLocks       map[string]*convLock       // The locks serializing the asks of each conversation, see lockConversation.
+	convLocksMu     sync.Mutex                 // Guards convLocks.
+}
+
+// Chatter is the minimal interface implemented by Client to start it and ask questions.
+// The chatgpttest package provides a configurable fake implementing it.
+type Chatter interface {
+	// Start initializes the client.
+	Start() error
+	// Ask sends a question and returns the response.
+	Ask(ctx context.Context, prompt string, askOpts ...AskOpts) (*ChatResponse, error)
+	// AskStream sends a question and streams the response through the returned channel.
+	AskStream(ctx context.Context, prompt string, askOpts ...AskOpts) (chan *ChatResponse, error)
+}
+
+// ChatClient is the interface implemented by Client.
+// Code depending on this package can accept a ChatClient instead of a *Client to substitute fakes in tests.
+type ChatClient interface {
+	Chatter
+	// AskInternet sends a question answered with the help of an internet search.
+	AskInternet(ctx context.Context, prompt string) (*ChatResponse, error)
+	// GetConversations returns a copy of all conversations currently stored in memory, see ConversationStore.List for stored ones.
+	GetConversations() map[string]Conversation
+	// GetConversation returns a specific conversation by ID.
+	GetConversation(id string) (*Conversation, error)
+	// SetConversation sets a specific conversation by ID.
+	SetConversation(id string, conv Conversation)
+	// ResetConversation deletes a specific conversation by ID.
+	ResetConversation(id string) error
+	// ResetConversations deletes all conversations from memory.
+	ResetConversations()
 }
 
+// Ensure that Client implements ChatClient and Chatter.
+var (
+	_ ChatClient = (*Client)(nil)
+	_ Chatter    = (*Client)(nil)
+)
+
 // Config represents the configuration options for a connection to the OpenAI API.
 // Each field is optional and can be omitted from the JSON representation of the config object.
 type Config struct {
-	ApiKey         string   `json:"api_key,omitempty"`         // The API key used for authentication with OpenAI.
-	Email          string   `json:"email,omitempty"`           // The email used for authentication with OpenAI.
-	Password       string   `json:"password,omitempty"`        // The password used for authentication with OpenAI.
-	AccessToken    string   `json:"access_token,omitempty"`    // The access token used for conversations with OpenAI.
-	Engine         string   `json:"engine,omitempty"`          // The name of the GPT model being used.
-	InitMessage    string   `json:"init_message,omitempty"`    // The initial message sent to start a new conversation.
-	BaseURL        string   `json:"base_url,omitempty"`        // Custom base URL for the OpenAI API.
-	Temperature    float64  `json:"temperature,omitempty"`     // The sampling temperature for generating text.
-	LogLevel       LogLevel `json:"log_level,omitempty"`       // The log level to use for logging messages.
-	IsPaid         bool     `json:"is_paid,omitempty"`         // Whether or not the account is a paid account.
-	EnableInternet bool     `json:"enable_internet,omitempty"` // Whether or not to allow the use of external websites in responses.
-	Stream         bool     `json:"stream,omitempty"`          // Whether or not to stream response messages as they come in.
-	DisableCache   bool     `json:"disable_cache,omitempty"`   // Whether or not to disable caching of access tokens.
-	Proxy          *url.URL `json:"proxy,omitempty"`           // The URL of the proxy server to use for requests.
+	ApiKey                    string                 `json:"api_key,omitempty"`                     // The API key used for authentication with OpenAI.
+	Email                     string                 `json:"email,omitempty"`                       // The email used for authentication with OpenAI.
+	Password                  string                 `json:"password,omitempty"`                    // The password used for authentication with OpenAI.
+	AccessToken               string                 `json:"access_token,omitempty"`                // The access token used for conversations with OpenAI.
+	Engine                    Engine                 `json:"engine,omitempty"`                      // The name of the GPT model being used, e.g. EngineGPT4.
+	InitMessage               string                 `json:"init_message,omitempty"`                // The initial message sent to start a new conversation.
+	BaseURL                   string                 `json:"base_url,omitempty"`                    // Custom base URL for the OpenAI API.
+	Temperature               float64                `json:"temperature,omitempty"`                 // The sampling temperature for generating text.
+	LogLevel                  LogLevel               `json:"log_level,omitempty"`                   // The log level to use for logging messages.
+	IsPaid                    bool                   `json:"is_paid,omitempty"`                     // Whether or not the account is a paid account.
+	EnableInternet            bool                   `json:"enable_internet,omitempty"`             // Whether or not to allow the use of external websites in responses.
+	Stream                    bool                   `json:"stream,omitempty"`                      // Whether or not Ask reads the responses as streams like AskStream, with the request timeout only applying until the first bytes (AccessTokenMode only).
+	DisableCache              bool                   `json:"disable_cache,omitempty"`               // Whether or not to disable caching of access tokens.
+	Proxy                     *url.URL               `json:"proxy,omitempty"`                       // The URL of the proxy server to use for requests, http, https or socks5, defaults to HTTPS_PROXY/HTTP_PROXY.
+	ValidateOnStart           *bool                  `json:"validate_on_start,omitempty"`           // Whether or not to validate the access token on Start, true when unset (AccessTokenMode only).
+	AutoContinue              int                    `json:"auto_continue,omitempty"`               // The maximum number of times a cut off response is automatically continued (AccessTokenMode only).
+	AuthBaseURL               string                 `json:"auth_base_url,omitempty"`               // Custom base URL of the token proxy used for email and password authentication.
+	AuthMethod                string                 `json:"auth_method,omitempty"`                 // The email and password authentication method, AuthMethodProxy (default) or AuthMethodDirect.
+	UserAgent                 string                 `json:"user_agent,omitempty"`                  // The User-Agent header, defaults to a browser one for access token and auth requests.
+	ExtraHeaders              map[string]string      `json:"extra_headers,omitempty"`               // Additional headers sent with access token and auth requests, never with api.openai.com, overriding Headers.
+	Headers                   map[string]string      `json:"headers,omitempty"`                     // Headers sent with every outbound request, including api.openai.com, e.g. those required by a proxy. They override the client's own headers.
+	RequestTimeout            time.Duration          `json:"request_timeout,omitempty"`             // The timeout of each request, for streams it only applies until the response headers arrive.
+	SkipInitMessage           bool                   `json:"skip_init_message,omitempty"`           // Whether or not to skip sending InitMessage as the first user message of new conversations in AccessTokenMode.
+	ArkoseProvider            ArkoseProvider         `json:"-"`                                     // The provider of Arkose tokens required for gpt-4 family models in AccessTokenMode.
+	N                         int                    `json:"n,omitempty"`                           // The number of completions to generate for each request (ApiKeyMode only).
+	LogitBias                 map[string]float64     `json:"logit_bias,omitempty"`                  // The biases of token IDs in [-100, 100] applied to each request (ApiKeyMode only).
+	Stop                      []string               `json:"stop,omitempty"`                        // Up to 4 sequences where the generation stops, applied client side in AccessTokenMode.
+	RecordFixtures            string                 `json:"record_fixtures,omitempty"`             // The directory where request/response pairs are recorded, with secrets redacted.
+	ReplayFixtures            string                 `json:"replay_fixtures,omitempty"`             // The directory of recorded fixtures to replay responses from, without network access.
+	ResponseCache             Cache                  `json:"-"`                                     // The cache of responses for identical prompts and conversation state (ApiKeyMode only), see NewMemoryCache.
+	CacheTTL                  time.Duration          `json:"cache_ttl,omitempty"`                   // The time to live of cached responses, zero means no expiry.
+	CacheMaxTemp              float64                `json:"cache_max_temp,omitempty"`              // The highest temperature at which responses are cached, defaults to 1.0.
+	AuthMode                  string                 `json:"auth_mode,omitempty"`                   // The authentication mode, AuthModeAuto (default), AuthModeApiKey or AuthModeAccessToken.
+	ExtraParams               map[string]interface{} `json:"extra_params,omitempty"`                // Additional parameters merged into each request payload, without overriding the fields set by the client (ApiKeyMode only).
+	AllowUnknownEngine        bool                   `json:"allow_unknown_engine,omitempty"`        // Whether or not to skip warning about engines that are not known, for custom or compatible backends.
+	ProxyCheckURL             string                 `json:"proxy_check_url,omitempty"`             // The URL requested through the proxy on Start to check it works, defaults to DEFAULT_PROXY_CHECK_URL.
+	Transport                 *TransportConfig       `json:"transport,omitempty"`                   // The connection tuning knobs applied when the client builds its own transport.
+	StrictConversations       bool                   `json:"strict_conversations,omitempty"`        // Whether or not asking with an unknown conversation ID is an error instead of starting a new conversation (ApiKeyMode only).
+	LegacyDefaultConversation bool                   `json:"legacy_default_conversation,omitempty"` // Whether or not questions without a conversation ID all go to the "default" conversation, as before generated conversation IDs (ApiKeyMode only).
+	DefaultConversationID     string                 `json:"default_conversation_id,omitempty"`     // The conversation all questions without a conversation ID go to, each one starts a new conversation if empty (ApiKeyMode only).
+	StatelessAsk              bool                   `json:"stateless_ask,omitempty"`               // Whether or not questions without a conversation ID are answered in a one-off conversation that isn't kept, unless DefaultConversationID is set (ApiKeyMode only).
+	MaxGeneratedConversations int                    `json:"max_generated_conversations,omitempty"` // The maximum number of conversations started without a conversation ID kept in memory, the least recently asked ones being evicted (they stay in the ConversationStore), defaults to DEFAULT_MAX_GENERATED_CONVERSATIONS, negative for no limit.
+	TokenStore                TokenStore             `json:"-"`                                     // Where access tokens are cached, defaults to the gpt-cache.json file, see NewFileTokenStore.
+	AuthRetries               int                    `json:"auth_retries,omitempty"`                // The number of attempts of each auth server call when it is unreachable, defaults to DEFAULT_AUTH_RETRIES.
+	StrictTemplates           bool                   `json:"strict_templates,omitempty"`            // Whether or not rendering a template fails on missing variables, see RegisterTemplate.
+	CompressionStrategy       string                 `json:"compression_strategy,omitempty"`        // How conversations reaching the token limit are shortened, CompressionTruncate (default) or CompressionSummarize (ApiKeyMode only).
+	CompressionThreshold      float64                `json:"compression_threshold,omitempty"`       // The fraction of the token limit at which conversations are summarized, defaults to DEFAULT_COMPRESSION_THRESHOLD.
+	CompressionMaxRuns        int                    `json:"compression_max_runs,omitempty"`        // The maximum number of summaries per conversation before falling back to truncation, defaults to DEFAULT_COMPRESSION_MAX_RUNS.
+	ResponseReserve           int                    `json:"response_reserve,omitempty"`            // The number of tokens reserved for the response when truncating conversations and by RemainingTokens, defaults to DEFAULT_RESPONSE_RESERVE.
+	ServerSideOnly            bool                   `json:"server_side_only,omitempty"`            // Whether or not conversations are only tracked by their conversation and parent IDs, their history being kept by the backend (AccessTokenMode only).
+	RateLimitWarnThreshold    float64                `json:"rate_limit_warn_threshold,omitempty"`   // The fraction of the rate limits below which a warning is logged, defaults to DEFAULT_RATE_LIMIT_WARN_THRESHOLD (ApiKeyMode only).
+	RateLimitPacing           bool                   `json:"rate_limit_pacing,omitempty"`           // Whether or not requests wait for the rate limits to reset once they are exhausted, instead of failing with a 429 error (ApiKeyMode only).
+	OnConversationCreated     ConversationHook       `json:"-"`                                     // Called when Ask creates a conversation for an unknown or missing conversation ID, e.g. to persist it, except for the one-off conversations of StatelessAsk.
+	AllowEmptyPrompt          bool                   `json:"allow_empty_prompt,omitempty"`          // Whether or not empty prompts are allowed, in API key mode the conversation is then sent without a new user message.
+	RedactRequest             func(string) string    `json:"-"`                                     // Applied to prompts before they are stored, logged or sent, e.g. RedactPII.
+	RedactResponse            func(string) string    `json:"-"`                                     // Applied to responses, including each streamed message, before they are stored, logged or returned, e.g. RedactPII.
+	ConversationStore         ConversationStore      `json:"-"`                                     // The durable storage of conversations, the in-memory conversations being a cache in front of it, see NewSQLConversationStore.
+	AutoRecreateConversations bool                   `json:"auto_recreate_conversations,omitempty"` // Whether or not Ask and AskStream start a new server-side conversation, with the same options, when the backend lost the asked one (AccessTokenMode only).
+	CookieJar                 http.CookieJar         `json:"-"`                                     // The cookie jar of the email and password login, defaults to a new one for each attempt.
+	ReplyLanguage             string                 `json:"reply_language,omitempty"`              // The language of the replies, an ISO 639-1 code like "fr" or "auto" to reply in the language of each prompt. Empty to let the model choose.
+	StreamIdleTimeout         time.Duration          `json:"stream_idle_timeout,omitempty"`         // The time without data after which a streamed response fails with ErrStreamStalled, defaults to DEFAULT_STREAM_IDLE_TIMEOUT, negative to disable (AccessTokenMode only).
+	StreamBufferSize          int                    `json:"stream_buffer_size,omitempty"`          // The capacity of the channels returned by AskStream and AskStreamRaw, defaults to DEFAULT_STREAM_BUFFER_SIZE.
+	SlowConsumerTimeout       time.Duration          `json:"slow_consumer_timeout,omitempty"`       // The time a stream waits for room in its full channel before it is aborted with ErrSlowConsumer, defaults to DEFAULT_SLOW_CONSUMER_TIMEOUT, negative to wait forever.
 }
 
 // NewClient creates a new OpenAI API client with the given configuration.
@@ -65,17 +249,65 @@ func NewClient(config *Config, sessionName ...string) *Client {
 			apiKey:      config.ApiKey,
 			accessToken: config.AccessToken,
 			enableCache: !config.DisableCache,
+			store:       config.TokenStore,
+			retries:     config.AuthRetries,
+			baseUrl:     config.AuthBaseURL,
+			method:      config.AuthMethod,
+			jar:         config.CookieJar,
 		},
-		conversations:  make(map[string]Conversation),
-		engine:         config.Engine,
-		baseUrl:        config.BaseURL,
-		temperature:    config.Temperature,
-		enableInternet: config.EnableInternet,
-		stream:         config.Stream,
-		httpx:          &http.Client{},
-		initMessage:    config.InitMessage,
-		ispaid:         config.IsPaid,
-		logger:         &Logger{},
+		conversations:   make(map[string]Conversation),
+		engine:          config.Engine,
+		baseUrl:         config.BaseURL,
+		temperature:     config.Temperature,
+		enableInternet:  config.EnableInternet,
+		stream:          config.Stream,
+		httpx:           &http.Client{},
+		initMessage:     config.InitMessage,
+		ispaid:          config.IsPaid,
+		logger:          &Logger{},
+		validate:        config.ValidateOnStart == nil || *config.ValidateOnStart,
+		autoContinue:    config.AutoContinue,
+		userAgent:       config.UserAgent,
+		extraHeaders:    config.ExtraHeaders,
+		headers:         config.Headers,
+		streams:         make(map[int]context.CancelFunc),
+		requestTimeout:  config.RequestTimeout,
+		noInitMessage:   config.SkipInitMessage,
+		arkose:          config.ArkoseProvider,
+		n:               config.N,
+		bias:            config.LogitBias,
+		stop:            config.Stop,
+		cache:           config.ResponseCache,
+		cacheTTL:        config.CacheTTL,
+		cacheMaxTemp:    config.CacheMaxTemp,
+		forceMode:       config.AuthMode,
+		extraParams:     config.ExtraParams,
+		anyEngine:       config.AllowUnknownEngine,
+		proxyCheck:      config.ProxyCheckURL,
+		strict:          config.StrictConversations,
+		defaultConv:     config.DefaultConversationID,
+		stateless:       config.StatelessAsk,
+		maxGenerated:    config.MaxGeneratedConversations,
+		generated:       make(map[string]*list.Element),
+		generatedOrder:  list.New(),
+		strictTemplates: config.StrictTemplates,
+		compression:     config.CompressionStrategy,
+		compressAt:      config.CompressionThreshold,
+		compressRuns:    config.CompressionMaxRuns,
+		reserve:         config.ResponseReserve,
+		serverOnly:      config.ServerSideOnly,
+		rateWarnAt:      config.RateLimitWarnThreshold,
+		ratePacing:      config.RateLimitPacing,
+		onCreated:       config.OnConversationCreated,
+		allowEmpty:      config.AllowEmptyPrompt,
+		redactReq:       config.RedactRequest,
+		redactResp:      config.RedactResponse,
+		convStore:       config.ConversationStore,
+		autoRecreate:    config.AutoRecreateConversations,
+		replyLang:       config.ReplyLanguage,
+		idleTimeout:     config.StreamIdleTimeout,
+		streamBuffer:    config.StreamBufferSize,
+		slowConsumer:    config.SlowConsumerTimeout,
 	}
 
 	// Set default values for missing fields in the configuration.
@@ -83,11 +315,29 @@ func NewClient(config *Config, sessionName ...string) *Client {
 		client.temperature = 0.9
 	}
 	if client.engine == "" {
-		client.engine = "gpt-3.5-turbo" // default engine
+		client.engine = EngineGPT35Turbo // default engine
+	}
+	if client.cacheMaxTemp == 0 {
+		client.cacheMaxTemp = 1.0
+	}
+	if client.defaultConv == "" && config.LegacyDefaultConversation {
+		client.defaultConv = "default"
+	}
+	if client.maxGenerated == 0 {
+		client.maxGenerated = DEFAULT_MAX_GENERATED_CONVERSATIONS
+	}
+	if client.idleTimeout == 0 {
+		client.idleTimeout = DEFAULT_STREAM_IDLE_TIMEOUT
+	}
+	if client.streamBuffer <= 0 {
+		client.streamBuffer = DEFAULT_STREAM_BUFFER_SIZE
+	}
+	if client.slowConsumer == 0 {
+		client.slowConsumer = DEFAULT_SLOW_CONSUMER_TIMEOUT
 	}
 	// set the default base URL if one is not specified in the configuration.
 	if client.baseUrl == "" {
-		client.baseUrl = "https://chat-api.ztorr.me/api/conversation"
+		client.baseUrl = DEFAULT_BASE_URL
 	}
 
 	// Set the log level if one is specified in the configuration.
@@ -107,14 +357,73 @@ func NewClient(config *Config, sessionName ...string) *Client {
 	}
 
 	// Set up a proxy if one is specified in the configuration.
-	if config.Proxy != nil {
-		client.httpx.Transport = &http.Transport{
-			Proxy: http.ProxyURL(config.Proxy),
+	client.transport = &switchTransport{rt: defaultTransport}
+	client.httpx.Transport = client.transport
+	client.tuning = config.Transport
+	if config.Proxy != nil || config.Transport != nil {
+		client.SetProxy(config.Proxy)
+	}
+
+	// Record or replay request/response pairs if a fixtures directory is specified in the configuration.
+	if config.RecordFixtures != "" || config.ReplayFixtures != "" {
+		next := client.httpx.Transport
+		transport := &fixtureTransport{next: next, dir: config.RecordFixtures, secrets: client.secrets}
+		if config.ReplayFixtures != "" {
+			transport.dir = config.ReplayFixtures
+			transport.replay = true
 		}
+		client.httpx.Transport = transport
 	}
+
+	// Share the HTTP client and browser headers with the auth flow, so that logging in goes through the same proxy.
+	client.auth.httpx = client.httpx
+	client.auth.setHeaders = client.setBrowserHeaders
 	return client
 }
 
+// secrets returns the credentials of the client, which must never be recorded or logged.
+func (c *Client) secrets() []string {
+	return []string{c.auth.apiKey, c.auth.accessToken, c.auth.password}
+}
+
+// redactSecrets replaces the credentials of the client found in text.
+func (c *Client) redactSecrets(text string) string {
+	for _, secret := range c.secrets() {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, "[REDACTED]")
+		}
+	}
+	return text
+}
+
+// SafeString describes the client for logs and debugging, with its credentials masked, e.g.
+// Client{session: default, mode: api_key, engine: gpt-3.5-turbo, api_key: sk-****ABCD}.
+func (c *Client) SafeString() string {
+	mode := AuthModeApiKey
+	if c.authmode == AccessTokenMode {
+		mode = AuthModeAccessToken
+	}
+	parts := []string{"session: " + c.auth.sessionName, "mode: " + mode, "engine: " + c.GetEngine()}
+	if c.auth.apiKey != "" {
+		parts = append(parts, "api_key: "+maskSecret(c.auth.apiKey))
+	}
+	if c.auth.email != "" {
+		parts = append(parts, "email: "+c.auth.email, "password: "+maskSecret(c.auth.password))
+	}
+	if c.auth.accessToken != "" {
+		parts = append(parts, "access_token: "+maskSecret(c.auth.accessToken))
+	}
+	if c.proxy != nil {
+		parts = append(parts, "proxy: "+c.proxy.Redacted())
+	}
+	return "Client{" + strings.Join(parts, ", ") + "}"
+}
+
+// String returns SafeString, so that printing a client never shows its credentials.
+func (c *Client) String() string {
+	return c.SafeString()
+}
+
 // SetEmailAndPassword sets the email and password used for authentication.
 func (c *Client) SetEmailAndPassword(email, password string) {
 	c.auth.email = email
@@ -137,26 +446,77 @@ func (c *Client) SetAccessToken(accessToken string) {
 }
 
 // SetEngine sets the GPT model being used.
-func (c *Client) SetEngine(engine string) {
-	c.logger.Debug(fmt.Sprintf("Setting engine to %s", engine))
+func (c *Client) SetEngine(engine Engine) {
+	c.logger.Debugf("Setting engine to %s", engine)
+	c.checkEngine(engine)
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
 	c.engine = engine
 }
 
 // ToggleInternet toggles whether or not to allow the use of external websites in responses.
 func (c *Client) ToggleInternet(t bool) {
-	c.logger.Debug(fmt.Sprintf("Setting enableInternet to %t", t))
+	c.logger.Debugf("Setting enableInternet to %t", t)
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
 	c.enableInternet = t
 }
 
-// ToggleStream toggles whether or not to stream response messages as they come in.
+// ToggleStream toggles whether or not Ask reads the responses as streams, see Config.Stream.
 func (c *Client) ToggleStream(t bool) {
-	c.logger.Debug(fmt.Sprintf("Setting stream to %t", t))
+	c.logger.Debugf("Setting stream to %t", t)
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
 	c.stream = t
 }
 
-// SetProxy sets the proxy server to use for requests.
+// SetUserAgent sets the User-Agent header sent with requests.
+func (c *Client) SetUserAgent(userAgent string) {
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
+	c.userAgent = userAgent
+}
+
+// SetExtraHeader sets an additional header sent with access token and auth requests.
+func (c *Client) SetExtraHeader(key, value string) {
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
+	if c.extraHeaders == nil {
+		c.extraHeaders = make(map[string]string)
+	}
+	c.extraHeaders[key] = value
+}
+
+// SetHeader sets a header sent with every outbound request, see Config.Headers.
+func (c *Client) SetHeader(key, value string) {
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
+	if c.headers == nil {
+		c.headers = make(map[string]string)
+	}
+	c.headers[key] = value
+}
+
+// SetArkoseProvider sets the provider of Arkose tokens for gpt-4 family models in access token mode.
+func (c *Client) SetArkoseProvider(provider ArkoseProvider) {
+	c.arkose = provider
+}
+
+// SetProxy sets the proxy server to use for requests, a nil proxy disables it.
+// HTTP(S) and SOCKS5 proxies are supported, with credentials set in the URL's user info.
+// The transport is swapped safely while requests are in flight, an invalid proxy is reported by Start.
 func (c *Client) SetProxy(proxy *url.URL) {
 	c.proxy = proxy
+	if proxy == nil && c.tuning == nil {
+		c.transport.set(defaultTransport)
+		return
+	}
+	transport, err := newTransport(proxy, c.tuning)
+	if err != nil {
+		c.logger.Errorf("Failed to set proxy: %s", err)
+		return
+	}
+	c.transport.set(transport)
 }
 
 // GetAPIKey returns the API key used for authentication.
@@ -169,18 +529,30 @@ func (c *Client) GetAccessToken() string {
 	return c.auth.accessToken
 }
 
+// AuthMode returns the authentication mode used by the client, ApiKeyMode or AccessTokenMode.
+// It is only meaningful once Start succeeded.
+func (c *Client) AuthMode() int {
+	return c.authmode
+}
+
 // GetEngine returns the name of the GPT model being used.
 func (c *Client) GetEngine() string {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
 	return c.engine
 }
 
 // GetEnableInternet returns true if external websites can be accessed in responses.
 func (c *Client) GetEnableInternet() bool {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
 	return c.enableInternet
 }
 
-// GetStream returns true if response messages are streamed as they come in.
+// GetStream returns true if Ask reads the responses as streams, see Config.Stream.
 func (c *Client) GetStream() bool {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
 	return c.stream
 }
 
@@ -189,46 +561,145 @@ func (c *Client) GetProxy() *url.URL {
 	return c.proxy
 }
 
-// GetConversations returns a map of all conversations currently stored in memory.
+// GetConversations returns a copy of all conversations currently stored in memory.
+// It is safe to use while other requests run, mutations to the returned copy don't affect the client state.
 func (c *Client) GetConversations() map[string]Conversation {
-	return c.conversations
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	conversations := make(map[string]Conversation, len(c.conversations))
+	for id, conv := range c.conversations {
+		conversations[id] = conv.clone()
+	}
+	return conversations
 }
 
-// GetConversation returns a specific conversation by ID, or an error if it doesn't exist.
+// GetConversation returns a copy of a specific conversation by ID, or an error if it doesn't exist.
+// A conversation not in memory is read from the conversation store, if any.
 func (c *Client) GetConversation(id string) (*Conversation, error) {
-	if conv, ok := c.conversations[id]; ok {
+	if conv, ok := c.cachedConversation(id); ok {
+		conv = conv.clone()
 		return &conv, nil
 	}
 	return nil, fmt.Errorf("conversation with id %s not found", id)
 }
 
-// SetConversation sets a specific conversation by ID.
+// ExportMarkdown returns a specific conversation by ID rendered as Markdown, see Conversation.ToMarkdown.
+func (c *Client) ExportMarkdown(id string) (string, error) {
+	conversation, err := c.GetConversation(id)
+	if err != nil {
+		return "", err
+	}
+	return conversation.ToMarkdown(), nil
+}
+
+// HasConversation returns true if a conversation with the given ID exists, in memory or in the conversation store.
+func (c *Client) HasConversation(id string) bool {
+	_, ok := c.cachedConversation(id)
+	return ok
+}
+
+// ConversationCount returns the number of conversations currently stored in memory.
+func (c *Client) ConversationCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.conversations)
+}
+
+// SetConversation sets a specific conversation by ID, and saves it to the conversation store, if any.
 func (c *Client) SetConversation(id string, conv Conversation) {
+	c.mu.Lock()
+	c.conversations[id] = conv
+	c.mu.Unlock()
+	c.saveConversation(id, conv)
+}
+
+// SetConversationEngine pins an engine on a specific conversation by ID, or returns an error if it doesn't exist.
+// An empty engine removes the pin, so that the client's engine is used again.
+func (c *Client) SetConversationEngine(id, engine string) error {
+	c.cachedConversation(id)
+	c.mu.Lock()
+	conv, ok := c.conversations[id]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("conversation with id %s not found", id)
+	}
+	conv.Engine = engine
 	c.conversations[id] = conv
+	c.mu.Unlock()
+	c.saveConversation(id, conv)
+	return nil
 }
 
-// ResetConversation deletes a specific conversation by ID, or returns an error if it doesn't exist.
+// conversationEngine returns the engine pinned on the given conversation, or the client's engine.
+func (c *Client) conversationEngine(id string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if conv, ok := c.conversations[id]; ok && conv.Engine != "" {
+		return conv.Engine
+	}
+	return c.GetEngine()
+}
+
+// ResetConversation deletes a specific conversation by ID, from memory and the conversation store,
+// or returns an error if it doesn't exist.
 func (c *Client) ResetConversation(id string) error {
-	if _, ok := c.conversations[id]; ok {
-		delete(c.conversations, id)
-		return nil
+	if _, ok := c.cachedConversation(id); !ok {
+		return fmt.Errorf("conversation with id %s not found", id)
+	}
+	c.mu.Lock()
+	delete(c.conversations, id)
+	c.forgetGenerated(id)
+	c.mu.Unlock()
+	if c.convStore != nil {
+		if err := c.convStore.Delete(id); err != nil {
+			return fmt.Errorf("failed to delete conversation %s: %w", id, err)
+		}
 	}
-	return fmt.Errorf("conversation with id %s not found", id)
+	return nil
 }
 
-// ResetConversations deletes all conversations from memory.
+// ResetConversations deletes all conversations from memory, the conversation store is left untouched.
 func (c *Client) ResetConversations() {
+	c.mu.Lock()
 	c.conversations = make(map[string]Conversation)
+	c.generated = make(map[string]*list.Element)
+	c.generatedOrder.Init()
+	c.mu.Unlock()
 	c.logger.Info("All conversations have been reset.")
 }
 
-// PingProxy checks if the proxy server is reachable.
-func (c *Client) pingProxy() error {
+// PingProxy checks that requests go through the proxy server, by sending a HEAD request to the proxy check URL through it.
+// Any response from the target counts as success, except 407 which is reported as ErrProxyAuthRequired.
+func (c *Client) pingProxy(ctx context.Context) error {
 	if c.proxy == nil {
 		return fmt.Errorf("no proxy server set")
 	}
-	_, err := c.httpx.Get(c.proxy.String())
-	return err
+	target := c.proxyCheck
+	if target == "" {
+		target = DEFAULT_PROXY_CHECK_URL
+	}
+	ctx, cancel := context.WithTimeout(ctx, proxyCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", target, nil)
+	if err != nil {
+		return fmt.Errorf("invalid proxy check URL: %w", err)
+	}
+	c.setCustomHeaders(req)
+
+	transport, err := newTransport(c.proxy, c.tuning)
+	if err != nil {
+		return err
+	}
+	httpx := &http.Client{Transport: transport}
+	resp, err := httpx.Do(req)
+	if err != nil {
+		return fmt.Errorf("proxy server %s is unreachable: %w", c.proxy.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return ErrProxyAuthRequired
+	}
+	return nil
 }
 
 // CheckCredentials checks that the client has been initialized with credentials.
@@ -238,6 +709,8 @@ func (c *Client) pingProxy() error {
 //	 1. API key
 //	 2. Email and password
 //	 3. Access token
+//
+// Config.AuthMode overrides this order.
 func (c *Client) checkCredentials() error {
 	if c.auth.apiKey == "" && (c.auth.email == "" || c.auth.password == "") && c.auth.accessToken == "" {
 		return fmt.Errorf("no credentials provided, please set an API key, email and password, or access token")
@@ -248,10 +721,64 @@ func (c *Client) checkCredentials() error {
 	return nil
 }
 
+// validateAccessToken checks the access token against the backend models endpoint.
+// A 401 or 403 response is reported as a ChatError with an "access token invalid or expired" message,
+// other failures of the endpoint are only logged.
+func (c *Client) validateAccessToken(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.backendUrl("models"), nil)
+	if err != nil {
+		return fmt.Errorf("system error: %w", err)
+	}
+	c.setHeaders(req, c.auth.accessToken)
+	c.setBrowserHeaders(req)
+
+	resp, err := c.httpx.Do(req)
+	if err != nil {
+		return fmt.Errorf("system error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &ChatError{Message: "access token invalid or expired", Code: resp.StatusCode}
+	default:
+		// The endpoint being down or rate limited says nothing about the token, asks will report the errors if any.
+		c.logger.Warnf("Could not validate the access token, the models endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// backendUrl returns the URL of the given backend endpoint, relative to the configured base URL.
+func (c *Client) backendUrl(endpoint string) string {
+	return strings.TrimSuffix(c.baseUrl, "/conversation") + "/" + endpoint
+}
+
 // Start initializes the client by checking credentials and authenticating with the OpenAI API.
+// It is StartContext with context.Background().
+// Start only needs to be called once, the calls made once the client is started are ignored and return nil.
 func (c *Client) Start() error {
+	return c.StartContext(context.Background())
+}
+
+// StartContext is like Start, but aborts the proxy check, the access token validation and the
+// email and password authentication once ctx is done.
+// Like Start it is safe to call several times, concurrent calls wait for the first one to finish.
+func (c *Client) StartContext(ctx context.Context) error {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+	if c.auth.clientStarted.Load() {
+		c.logger.Debug("Client is already started")
+		return nil
+	}
+
 	// Check that the client has been initialized with credentials.
-	c.auth.loadCachedAccessToken()
+	if loaded := c.auth.loadCachedAccessToken(); c.auth.enableCache {
+		c.emitCacheLookup(CacheToken, loaded)
+	}
 	if err := c.checkCredentials(); err != nil {
 		return err
 	}
@@ -259,16 +786,52 @@ func (c *Client) Start() error {
 	if c.proxy != nil {
 		// check if proxy is alive, ping it
 		// if not, return error
-		if err := c.pingProxy(); err != nil {
+		if err := c.pingProxy(ctx); err != nil {
 			return err
 		}
 		c.logger.Debug("Proxy server is alive")
 	}
-	if c.auth.apiKey != "" {
+	useApiKey := c.auth.apiKey != ""
+	switch c.forceMode {
+	case "", AuthModeAuto:
+	case AuthModeApiKey:
+		if !useApiKey {
+			return fmt.Errorf("auth mode %s requires an API key", c.forceMode)
+		}
+	case AuthModeAccessToken:
+		if c.auth.accessToken == "" && (c.auth.email == "" || c.auth.password == "") {
+			return fmt.Errorf("auth mode %s requires an access token or email and password", c.forceMode)
+		}
+		useApiKey = false
+	default:
+		return fmt.Errorf("unknown auth mode: %s", c.forceMode)
+	}
+
+	if useApiKey {
 		c.authmode = ApiKeyMode
 		c.logger.Info("Starting client with API key Authentication")
+		if c.GetStream() {
+			c.logger.Warn("Stream is ignored in API key mode, which doesn't stream responses yet")
+		}
 	} else if c.auth.accessToken != "" {
 		c.authmode = AccessTokenMode
+		if c.validate {
+			if err := c.validateAccessToken(ctx); err != nil {
+				// A stale cached token can be replaced if email and password are available.
+				chatErr, ok := err.(*ChatError)
+				if !ok || (chatErr.Code != http.StatusUnauthorized && chatErr.Code != http.StatusForbidden) || c.auth.email == "" || c.auth.password == "" {
+					return err
+				}
+				c.logger.Info("Access token is invalid or expired, re-authenticating with email and password")
+				accessToken, err := c.auth.refreshAccessToken(ctx)
+				if err != nil {
+					return err
+				}
+				c.auth.accessToken = accessToken
+				c.emit(Event{Type: EventTokenRefreshed})
+			}
+			c.logger.Debug("Access token is valid")
+		}
 		if c.auth.enableCache {
 			if err := c.auth.cacheAccessToken(); err != nil {
 				return err
@@ -276,91 +839,186 @@ func (c *Client) Start() error {
 		}
 		c.logger.Info("Starting client with access token Authentication")
 		if !c.ispaid {
-			c.engine = "text-davinci-002-render-sha"
-			c.logger.Debug("Using free engine: " + c.engine)
+			c.settingsMu.Lock()
+			c.engine = EngineChatGPTFree
+			c.settingsMu.Unlock()
+			c.logger.Debugf("Using free engine: %s", EngineChatGPTFree)
 		}
 	} else if c.auth.email != "" && c.auth.password != "" {
 		// Authenticate with the OpenAI API and set the access token.
 		c.logger.Info("Starting client with email and password Authentication")
-		accessToken, err := c.auth.GetAccessToken()
+		accessToken, err := c.auth.GetAccessToken(ctx)
 		if err != nil {
 			return err
 		}
 		c.logger.Info("Successfully authenticated with OpenAI")
 		c.auth.accessToken = accessToken
+		c.emit(Event{Type: EventTokenRefreshed})
 		c.authmode = AccessTokenMode
 		if !c.ispaid {
-			c.engine = "text-davinci-002-render-sha"
-			c.logger.Debug("Using free engine: " + c.engine)
+			c.settingsMu.Lock()
+			c.engine = EngineChatGPTFree
+			c.settingsMu.Unlock()
+			c.logger.Debugf("Using free engine: %s", EngineChatGPTFree)
 		}
 	}
-	c.auth.clientStarted = true
+	c.checkEngine(c.GetEngine())
+	c.auth.clientStarted.Store(true)
 	return nil
 }
 
-// Logger Module
-
-// Logger is a simple logger that can be used to log messages to the console.
-type Logger struct {
-	// The minimum level of messages to log.
-	Level LogLevel
-	// sessionName is the name of the session.
-	sessionName string
+// Started returns true if the client was successfully started with Start and not closed since.
+func (c *Client) Started() bool {
+	return c.auth.clientStarted.Load()
 }
 
-// SetLevel sets the minimum level of messages to log.
-func (l *Logger) SetLevel(level LogLevel) {
-	l.Level = level
+// Close releases the resources held by the client: it cancels any in-flight streams,
+// closes idle HTTP connections, stops the event dispatcher and flushes the access token cache.
+// The client must not be used after Close.
+func (c *Client) Close() error {
+	c.streamsMu.Lock()
+	for id, cancel := range c.streams {
+		cancel()
+		delete(c.streams, id)
+		c.emit(Event{Type: EventStreamEnded})
+	}
+	c.streamsMu.Unlock()
+
+	c.httpx.CloseIdleConnections()
+	c.startMu.Lock()
+	c.auth.clientStarted.Store(false)
+	c.startMu.Unlock()
+
+	// Stop the event dispatcher once the queued events are handled
+	c.eventsMu.Lock()
+	if c.events != nil {
+		close(c.events)
+		c.events = nil
+	}
+	c.eventsMu.Unlock()
+
+	if c.auth.enableCache && c.auth.accessToken != "" {
+		return c.auth.cacheAccessToken()
+	}
+	return nil
 }
 
-// LogLevel is an enum for the different log levels.
-type LogLevel int
+// trackStream registers the cancel function of an in-flight stream and returns its ID.
+func (c *Client) trackStream(cancel context.CancelFunc) int {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	c.nextStreamID++
+	c.streams[c.nextStreamID] = cancel
+	c.emit(Event{Type: EventStreamStarted})
+	return c.nextStreamID
+}
 
-const (
-	// LogLevelNone disables all logging.
-	LogLevelNone LogLevel = iota
-	// LogLevelDebug logs debug messages.
-	LogLevelDebug
-	// LogLevelInfo logs informational messages.
-	LogLevelInfo
-	// LogLevelWarn logs warning messages.
-	LogLevelWarn
-	// LogLevelError logs error messages.
-	LogLevelError
-)
+// ActiveStreams returns the number of streamed responses currently open.
+func (c *Client) ActiveStreams() int {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	return len(c.streams)
+}
 
-// SessionName returns the name of the session, or an empty string if it's the default session.
-func (l *Logger) SessionName() string {
-	if l.sessionName == "default" {
-		return ""
+// untrackStream removes a finished stream from the in-flight streams.
+func (c *Client) untrackStream(id int) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	if _, ok := c.streams[id]; ok {
+		delete(c.streams, id)
+		c.emit(Event{Type: EventStreamEnded})
 	}
-	return l.sessionName
 }
 
-// Debug logs a debug message.
-func (l *Logger) Debug(msg string) {
-	if l.Level <= LogLevelDebug {
-		log.Printf("chatgpt%s - Debug - %s", l.SessionName(), msg)
+// cancelFuncs are the cancel functions of in-flight requests, keyed by request ID.
+type cancelFuncs map[int]context.CancelFunc
+
+// CancelConversation cancels the in-flight requests and streams of the conversation with the given ID,
+// e.g. when the user stops it, they fail with context.Canceled. The requests of other conversations are not affected.
+func (c *Client) CancelConversation(id string) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	for _, cancel := range c.requests[id] {
+		cancel()
 	}
+	delete(c.requests, id)
 }
 
-// Info logs an informational message.
-func (l *Logger) Info(msg string) {
-	if l.Level <= LogLevelInfo {
-		log.Printf("chatgpt%s - Info - %s", l.SessionName(), msg)
+// trackConversation registers the cancel function of an in-flight request of a conversation for CancelConversation,
+// and returns the function removing it once the request is done. Requests without a conversation ID are not tracked.
+func (c *Client) trackConversation(id string, cancel context.CancelFunc) func() {
+	if id == "" {
+		return func() {}
+	}
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	if c.requests == nil {
+		c.requests = make(map[string]cancelFuncs)
+	}
+	if c.requests[id] == nil {
+		c.requests[id] = make(cancelFuncs)
+	}
+	c.nextRequestID++
+	requestID := c.nextRequestID
+	c.requests[id][requestID] = cancel
+	return func() {
+		c.streamsMu.Lock()
+		defer c.streamsMu.Unlock()
+		delete(c.requests[id], requestID)
+		if len(c.requests[id]) == 0 {
+			delete(c.requests, id)
+		}
 	}
 }
 
-// Warn logs a warning message.
-func (l *Logger) Warn(msg string) {
-	if l.Level <= LogLevelWarn {
-		log.Printf("chatgpt%s - Warn - %s", l.SessionName(), msg)
+// withConversationCancel returns a copy of ctx which is cancelled by CancelConversation(id),
+// and the function to call once the request is done.
+func (c *Client) withConversationCancel(ctx context.Context, id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	untrack := c.trackConversation(id, cancel)
+	return ctx, func() {
+		untrack()
+		cancel()
 	}
 }
 
-// Error logs an error message.
-func (l *Logger) Error(msg string) {
-	if l.Level <= LogLevelError {
-		log.Printf("chatgpt%s - Error - %s", l.SessionName(), msg)
+// convLock serializes the asks of a conversation, it is removed once no ask holds or waits for it.
+type convLock struct {
+	sem  chan struct{} // Holds a value while an ask of the conversation runs.
+	refs int           // The number of asks holding or waiting for sem.
+}
+
+// lockConversation waits for the running ask of the conversation with the given ID to finish, so that concurrent asks
+// of a conversation add their turns one after the other instead of overwriting each other's, and returns the function
+// to call once the ask is done. It returns the error of ctx if it is done first.
+func (c *Client) lockConversation(ctx context.Context, id string) (func(), error) {
+	c.convLocksMu.Lock()
+	if c.convLocks == nil {
+		c.convLocks = make(map[string]*convLock)
+	}
+	lock, ok := c.convLocks[id]
+	if !ok {
+		lock = &convLock{sem: make(chan struct{}, 1)}
+		c.convLocks[id] = lock
+	}
+	lock.refs++
+	c.convLocksMu.Unlock()
+
+	unref := func() {
+		c.convLocksMu.Lock()
+		defer c.convLocksMu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(c.convLocks, id)
+		}
+	}
+	select {
+	case lock.sem <- struct{}{}:
+		return func() {
+			<-lock.sem
+			unref()
+		}, nil
+	case <-ctx.Done():
+		unref()
+		return nil, ctx.Err()
 	}
 }
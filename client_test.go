@@ -0,0 +1,181 @@
+package chatgpt_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+// startApiKeyClient starts a client in API key mode asking server, OPENAI_HOST is restored once the test is done.
+func startApiKeyClient(t *testing.T, server *chatgpttest.Server, config chatgpt.Config) *chatgpt.Client {
+	t.Helper()
+	host := chatgpt.OPENAI_HOST
+	chatgpt.OPENAI_HOST = server.APIURL()
+	t.Cleanup(func() { chatgpt.OPENAI_HOST = host })
+
+	if config.ApiKey == "" {
+		config.ApiKey = "sk-test"
+	}
+	return startClient(t, config)
+}
+
+// startAccessTokenClient starts a client in access token mode asking server.
+func startAccessTokenClient(t *testing.T, server *chatgpttest.Server, config chatgpt.Config) *chatgpt.Client {
+	t.Helper()
+	if config.AccessToken == "" {
+		config.AccessToken = "token"
+	}
+	config.BaseURL = server.ConversationURL()
+	return startClient(t, config)
+}
+
+// startClient starts a client with the given config, without caching the access token and only logging errors.
+func startClient(t *testing.T, config chatgpt.Config) *chatgpt.Client {
+	t.Helper()
+	config.DisableCache = true
+	if config.LogLevel == 0 {
+		config.LogLevel = chatgpt.LogLevelError
+	}
+	client := chatgpt.NewClient(&config)
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestStartValidatesAccessToken(t *testing.T) {
+	validate, skip := true, false
+	for _, test := range []struct {
+		status   int
+		validate *bool
+		wantErr  bool
+	}{
+		{http.StatusOK, nil, false},
+		{http.StatusUnauthorized, nil, true},
+		{http.StatusForbidden, &validate, true},
+		{http.StatusTooManyRequests, nil, false},
+		{http.StatusBadGateway, nil, false},
+		{http.StatusUnauthorized, &skip, false},
+	} {
+		server := chatgpttest.NewServer()
+		server.ModelsStatus = test.status
+		client := chatgpt.NewClient(&chatgpt.Config{
+			AccessToken:     "token",
+			BaseURL:         server.ConversationURL(),
+			DisableCache:    true,
+			LogLevel:        chatgpt.LogLevelError,
+			ValidateOnStart: test.validate,
+		})
+		err := client.Start()
+		var chatErr *chatgpt.ChatError
+		if test.wantErr && (!errors.As(err, &chatErr) || chatErr.Code != test.status) {
+			t.Errorf("status %d: got error %v, want an auth error", test.status, err)
+		}
+		if !test.wantErr && err != nil {
+			t.Errorf("status %d: got error %v", test.status, err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+// TestConcurrentSettings changes the settings while questions are asked, run it with -race.
+func TestConcurrentSettings(t *testing.T) {
+	const asks = 20
+	server := chatgpttest.NewServer()
+	defer server.Close()
+	for i := 0; i < asks; i++ {
+		server.AddResponses(chatgpttest.Response{Message: "Hi"})
+	}
+	client := startApiKeyClient(t, server, chatgpt.Config{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < asks; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Ask(context.Background(), "Hello"); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			engine := chatgpt.EngineGPT35Turbo
+			if i%2 == 1 {
+				engine = chatgpt.EngineGPT4o
+			}
+			client.SetEngine(engine)
+			client.ToggleInternet(i%2 == 1)
+			client.ToggleStream(i%2 == 1)
+			client.SetUserAgent(fmt.Sprintf("agent/%d", i))
+			client.SetExtraHeader("X-Test", fmt.Sprint(i))
+			_ = client.GetEngine()
+			_ = client.GetEnableInternet()
+			_ = client.GetStream()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, request := range server.Requests() {
+		if model := request.Body["model"]; model != chatgpt.EngineGPT35Turbo && model != chatgpt.EngineGPT4o {
+			t.Errorf("got model %v", model)
+		}
+	}
+	client.SetEngine(chatgpt.EngineGPT4)
+	client.ToggleInternet(true)
+	if client.GetEngine() != chatgpt.EngineGPT4 || !client.GetEnableInternet() {
+		t.Errorf("got engine %s and internet %t after setting them", client.GetEngine(), client.GetEnableInternet())
+	}
+}
+
+// TestAskDuringStartAndClose asks questions while the client starts and closes, run it with -race.
+func TestAskDuringStartAndClose(t *testing.T) {
+	server := chatgpttest.NewServer()
+	defer server.Close()
+	host := chatgpt.OPENAI_HOST
+	chatgpt.OPENAI_HOST = server.APIURL()
+	defer func() { chatgpt.OPENAI_HOST = host }()
+	client := chatgpt.NewClient(&chatgpt.Config{ApiKey: "sk-test", DisableCache: true, LogLevel: chatgpt.LogLevelError})
+
+	var wg, asking sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		asking.Add(1)
+		go func() {
+			defer wg.Done()
+			asking.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// The errors of a client not started yet, closed or out of scripted responses are expected
+				client.Ask(context.Background(), "Hello")
+				client.AskWithMessages(context.Background(), []chatgpt.Message{{Role: "user", Content: "Hello"}})
+				client.AskStream(context.Background(), "Hello")
+				_ = client.Started()
+			}
+		}()
+	}
+	asking.Wait()
+	if err := client.Start(); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	client.Close()
+	close(done)
+	wg.Wait()
+	if client.Started() {
+		t.Error("the client is still started after Close")
+	}
+}
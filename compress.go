@@ -0,0 +1,97 @@
+package chatgpt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptCompression returns a copy of req asking for a gzip or deflate compressed response,
+// or req itself if it already has an Accept-Encoding header.
+func acceptCompression(req *http.Request) *http.Request {
+	if req.Header.Get("Accept-Encoding") != "" {
+		return req
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	return req
+}
+
+// decompressResponse replaces the body of a gzip or deflate compressed response with a decompressing reader.
+// The body is decompressed as it is read, so that compressed event streams are decoded before the scanner.
+func decompressResponse(resp *http.Response) {
+	if resp.Request != nil && resp.Request.Method == "HEAD" {
+		return
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != "gzip" && encoding != "deflate" {
+		return
+	}
+	resp.Body = &decompressedBody{body: resp.Body, encoding: encoding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// decompressedBody decompresses a response body, the decompressor is created on the first read
+// since it blocks until the compression header is received.
+type decompressedBody struct {
+	body     io.ReadCloser
+	encoding string
+	reader   io.Reader
+	err      error
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		if b.encoding == "gzip" {
+			b.reader, b.err = gzip.NewReader(b.body)
+		} else {
+			b.reader, b.err = zlib.NewReader(b.body)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+func (b *decompressedBody) Close() error {
+	return b.body.Close()
+}
+
+// sniffGzip returns body decompressed if it starts with the gzip magic bytes, for proxies that compress
+// responses without a Content-Encoding header. Other bodies are returned unchanged.
+func sniffGzip(body io.ReadCloser) io.ReadCloser {
+	return &gzipSniffer{body: body}
+}
+
+// gzipSniffer checks the first bytes of a body on the first read, so that streams aren't blocked until then.
+type gzipSniffer struct {
+	body   io.ReadCloser
+	reader io.Reader
+	err    error
+}
+
+func (s *gzipSniffer) Read(p []byte) (int, error) {
+	if s.reader == nil && s.err == nil {
+		buffered := bufio.NewReader(s.body)
+		if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+			s.reader, s.err = gzip.NewReader(buffered)
+		} else {
+			s.reader = buffered
+		}
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.reader.Read(p)
+}
+
+func (s *gzipSniffer) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,83 @@
+package chatgpt_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amarnathcjd/chatgpt"
+)
+
+const (
+	compressedCompletion = `{"id": "chatcmpl-1", "model": "gpt-3.5-turbo", "choices": [{"index": 0, "message": {"role": "assistant", "content": "Compressed"}, "finish_reason": "stop"}]}`
+	compressedStream     = "\n" + `data: {"message": {"id": "m1", "author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Sniffed"]}}, "conversation_id": "c1"}` + "\n\ndata: [DONE]\n\n"
+)
+
+// compress returns data compressed with gzip or deflate.
+func compress(t *testing.T, encoding, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser = gzip.NewWriter(&buf)
+	if encoding == "deflate" {
+		w = zlib.NewWriter(&buf)
+	}
+	io.WriteString(w, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressedCompletions(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		var acceptEncoding string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(compress(t, encoding, compressedCompletion))
+		}))
+		host := chatgpt.OPENAI_HOST
+		chatgpt.OPENAI_HOST = server.URL + "/v1/chat/completions"
+		client := startClient(t, chatgpt.Config{ApiKey: "sk-test"})
+
+		response, err := client.Ask(context.Background(), "Hi")
+		if err != nil {
+			t.Errorf("%s: %v", encoding, err)
+		} else if response.Message != "Compressed" {
+			t.Errorf("%s: got %q", encoding, response.Message)
+		}
+		if acceptEncoding != "gzip, deflate" {
+			t.Errorf("%s: got Accept-Encoding %q", encoding, acceptEncoding)
+		}
+		chatgpt.OPENAI_HOST = host
+		server.Close()
+	}
+}
+
+func TestGzippedStreamWithoutContentEncoding(t *testing.T) {
+	// Like a proxy compressing the event stream without saying so
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(compress(t, "gzip", compressedStream))
+	}))
+	defer server.Close()
+	client := startClient(t, chatgpt.Config{AccessToken: "token", BaseURL: server.URL + "/conversation", ValidateOnStart: new(bool)})
+
+	ch, err := client.AskStream(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last *chatgpt.ChatResponse
+	for message := range ch {
+		last = message
+	}
+	if last == nil || last.Err != nil || last.Message != "Sniffed" {
+		t.Errorf("got last message %+v", last)
+	}
+}
@@ -0,0 +1,172 @@
+package chatgpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The environment variables read by NewClientFromEnv.
+const (
+	EnvConfig      = "CHATGPT_CONFIG"       // The path of a JSON config file loaded with LoadConfig, other variables override its values.
+	EnvApiKey      = "OPENAI_API_KEY"       // The API key used for authentication with OpenAI.
+	EnvAccessToken = "CHATGPT_ACCESS_TOKEN" // The access token used for conversations with OpenAI.
+	EnvEmail       = "CHATGPT_EMAIL"        // The email used for authentication with OpenAI.
+	EnvPassword    = "CHATGPT_PASSWORD"     // The password used for authentication with OpenAI.
+	EnvEngine      = "CHATGPT_ENGINE"       // The name of the GPT model being used.
+	EnvBaseURL     = "CHATGPT_BASE_URL"     // Custom base URL for the OpenAI API.
+	EnvProxy       = "CHATGPT_PROXY"        // The URL of the proxy server, takes precedence over HTTPS_PROXY and HTTP_PROXY.
+	EnvLogLevel    = "CHATGPT_LOG_LEVEL"    // The log level, one of debug, info, warn, error or none.
+)
+
+// NewClientFromEnv creates a new client with a configuration read from the environment.
+//
+//	Values are taken in the following order, later ones overriding earlier ones:
+//	 1. The JSON config file at CHATGPT_CONFIG, if set
+//	 2. HTTP_PROXY, then HTTPS_PROXY
+//	 3. OPENAI_API_KEY, CHATGPT_ACCESS_TOKEN, CHATGPT_EMAIL, CHATGPT_PASSWORD, CHATGPT_ENGINE, CHATGPT_BASE_URL, CHATGPT_PROXY and CHATGPT_LOG_LEVEL
+//
+// Credentials are not checked here, Start validates them as usual.
+func NewClientFromEnv(sessionName ...string) (*Client, error) {
+	config := &Config{}
+	if path := os.Getenv(EnvConfig); path != "" {
+		var err error
+		if config, err = LoadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+
+	setFromEnv(&config.ApiKey, EnvApiKey)
+	setFromEnv(&config.AccessToken, EnvAccessToken)
+	setFromEnv(&config.Email, EnvEmail)
+	setFromEnv(&config.Password, EnvPassword)
+	setFromEnv(&config.Engine, EnvEngine)
+	setFromEnv(&config.BaseURL, EnvBaseURL)
+
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", EnvProxy} {
+		if value := os.Getenv(name); value != "" {
+			proxy, err := url.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy URL in %s: %w", name, err)
+			}
+			config.Proxy = proxy
+		}
+	}
+
+	if value := os.Getenv(EnvLogLevel); value != "" {
+		level, err := parseLogLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", EnvLogLevel, err)
+		}
+		config.LogLevel = level
+	}
+	return NewClient(config, sessionName...), nil
+}
+
+// setFromEnv sets field to the value of the environment variable name, if it is set.
+func setFromEnv(field *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*field = value
+	}
+}
+
+// parseLogLevel parses a log level name or number.
+func parseLogLevel(value string) (LogLevel, error) {
+	switch strings.ToLower(value) {
+	case "none":
+		return LogLevelNone, nil
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level < int(LogLevelNone) || level > int(LogLevelError) {
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+	return LogLevel(level), nil
+}
+
+// LoadConfig reads a configuration from the JSON file at path, using the json tags of Config.
+// The proxy is given as a URL string, unknown fields are reported as errors.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// The proxy is a string in the file, it shadows the *url.URL field of Config
+	type config Config
+	var file struct {
+		*config
+		Proxy string `json:"proxy,omitempty"`
+	}
+	file.config = &config{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if file.Proxy != "" {
+		proxy, err := url.Parse(file.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL in config file %s: %w", path, err)
+		}
+		file.config.Proxy = proxy
+	}
+	return (*Config)(file.config), nil
+}
+
+// MarshalJSON encodes the configuration like LoadConfig reads it, with the proxy as a URL string,
+// but with the API key, password, access token and proxy password masked so that it can be logged safely.
+// The result can't be loaded back to authenticate.
+func (c Config) MarshalJSON() ([]byte, error) {
+	type config Config
+	masked := config(c)
+	masked.ApiKey = maskSecret(c.ApiKey)
+	masked.Password = maskSecret(c.Password)
+	masked.AccessToken = maskSecret(c.AccessToken)
+	file := struct {
+		config
+		Proxy string `json:"proxy,omitempty"`
+	}{config: masked}
+	if c.Proxy != nil {
+		file.Proxy = c.Proxy.Redacted()
+	}
+	return json.Marshal(file)
+}
+
+// String returns the configuration as JSON with its secrets masked, see MarshalJSON.
+func (c Config) String() string {
+	data, err := c.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("Config{%v}", err)
+	}
+	return string(data)
+}
+
+// maskSecret masks a secret for display, keeping only a short prefix like "sk-" and its last 4 characters,
+// e.g. sk-****ABCD. Short secrets are masked entirely.
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) < 12 {
+		return "****"
+	}
+	var prefix string
+	if i := strings.Index(secret, "-"); i > 0 && i <= 4 {
+		prefix = secret[:i+1]
+	}
+	return prefix + "****" + secret[len(secret)-4:]
+}
@@ -0,0 +1,60 @@
+package chatgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSlowConsumer is returned when a stream is aborted because its channel stayed full for Config.SlowConsumerTimeout.
+var ErrSlowConsumer = errors.New("stream consumer too slow")
+
+// The default capacity of the channels returned by AskStream and AskStreamRaw, see Config.StreamBufferSize.
+const DEFAULT_STREAM_BUFFER_SIZE = 60
+
+// The default time a stream waits for room in a full channel before it is aborted, see Config.SlowConsumerTimeout.
+const DEFAULT_SLOW_CONSUMER_TIMEOUT = 30 * time.Second
+
+// sendStream sends v to the channel of a stream, waiting for room up to Config.SlowConsumerTimeout.
+// It returns an error wrapping ErrSlowConsumer if the channel stayed full, or the error of ctx once it is done.
+func sendStream[T any](ctx context.Context, ch chan T, v T, timeout time.Duration) error {
+	select {
+	case ch <- v:
+		return nil
+	default:
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case ch <- v:
+		return nil
+	case <-expired:
+		return fmt.Errorf("%w: the channel was full for %s", ErrSlowConsumer, timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// replaceOldest sends v to a full channel that is no longer waited on, dropping its oldest message to make room.
+// The messages of AskStream hold the whole response so far, so the newer ones still have the text of the dropped one.
+func replaceOldest[T any](ch chan T, v T) {
+	select {
+	case ch <- v:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- v:
+	default:
+	}
+}
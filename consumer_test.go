@@ -0,0 +1,161 @@
+package chatgpt_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+// longReply is a reply streamed in many chunks, more than the buffer of the streams of these tests.
+var longReply = strings.TrimSpace(strings.Repeat("chunk ", 20))
+
+// clientGoroutines returns the stacks of the goroutines running or started by the chatgpt package,
+// leaving out those of the tests and of chatgpttest.
+func clientGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var stacks []string
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, "github.com/amarnathcjd/chatgpt.") && !strings.Contains(stack, "github.com/amarnathcjd/chatgpt_test.") {
+			stacks = append(stacks, stack)
+		}
+	}
+	return stacks
+}
+
+// checkGoroutineLeaks fails the test if the client has more goroutines once the test is done than when it was called,
+// e.g. a stream still blocked on its channel. The goroutines of the started client, like its event dispatcher, are the baseline.
+func checkGoroutineLeaks(t *testing.T) {
+	t.Helper()
+	baseline := len(clientGoroutines())
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stacks := clientGoroutines()
+			if len(stacks) <= baseline {
+				return
+			} else if time.Now().After(deadline) {
+				t.Errorf("%d goroutines leaked:\n\n%s", len(stacks)-baseline, strings.Join(stacks, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestAskStreamAbortsSlowConsumers(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: longReply})
+	defer server.Close()
+	client := startAccessTokenClient(t, server, chatgpt.Config{StreamBufferSize: 1, SlowConsumerTimeout: 50 * time.Millisecond})
+	checkGoroutineLeaks(t)
+
+	ch, err := client.AskStream(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond) // don't read the channel past the timeout
+
+	var last *chatgpt.ChatResponse
+	count := 0
+	for message := range ch {
+		last = message
+		count++
+	}
+	if last == nil || !errors.Is(last.Err, chatgpt.ErrSlowConsumer) {
+		t.Fatalf("got last message %+v, want ErrSlowConsumer", last)
+	}
+	if !last.Partial || last.Message == "" || last.Message == longReply {
+		t.Errorf("got %+v, want the partial response", last)
+	}
+	if count > 2 {
+		t.Errorf("got %d messages, want the buffered one and the error", count)
+	}
+
+	// The stream is released once aborted
+	deadline := time.Now().Add(time.Second)
+	for client.ActiveStreams() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if streams := client.ActiveStreams(); streams != 0 {
+		t.Errorf("got %d active streams after the abort", streams)
+	}
+}
+
+func TestAskStreamWaitsForSlowConsumers(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: longReply})
+	defer server.Close()
+	client := startAccessTokenClient(t, server, chatgpt.Config{StreamBufferSize: 1, SlowConsumerTimeout: -1})
+
+	ch, err := client.AskStream(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last *chatgpt.ChatResponse
+	for message := range ch {
+		time.Sleep(10 * time.Millisecond)
+		last = message
+	}
+	if last == nil || last.Err != nil || last.Message != longReply {
+		t.Errorf("got last message %+v, want the whole reply", last)
+	}
+}
+
+func TestAskStreamCancel(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: longReply})
+	server.ChunkDelay = 20 * time.Millisecond
+	defer server.Close()
+	client := startAccessTokenClient(t, server, chatgpt.Config{})
+	checkGoroutineLeaks(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := client.AskStream(ctx, "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	cancel()
+	var last *chatgpt.ChatResponse
+	timeout := time.After(2 * time.Second)
+	for done := false; !done; {
+		select {
+		case message, ok := <-ch:
+			if !ok {
+				done = true
+				break
+			}
+			last = message
+		case <-timeout:
+			t.Fatal("the channel wasn't closed after the cancellation")
+		}
+	}
+	if last == nil || last.Err == nil {
+		t.Errorf("got last message %+v, want the cancellation error", last)
+	}
+}
+
+func TestAskStreamAbandoned(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: longReply})
+	defer server.Close()
+	client := startAccessTokenClient(t, server, chatgpt.Config{StreamBufferSize: 1, SlowConsumerTimeout: 50 * time.Millisecond})
+	checkGoroutineLeaks(t)
+
+	// The caller reads the first message and forgets the channel
+	ch, err := client.AskStream(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+}
@@ -1,18 +1,150 @@
 package chatgpt
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrConversationNotFound is returned when asking with an unknown conversation ID, if AskOpts.RequireExisting or Config.StrictConversations is set.
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ErrRemoteConversationGone is returned in access token mode when the backend deleted or expired the asked conversation,
+// unless Config.AutoRecreateConversations is set. The local conversation is kept, reset it to start over.
+var ErrRemoteConversationGone = errors.New("conversation is gone on the backend")
 
 // Message represents a struct with two fields: Role and Content.
 type Message struct {
 	Role    string `json:"role,omitempty"`    // Tag defies the JSON key name as "role" or omits the key if the value is empty.
 	Content string `json:"content,omitempty"` // Tag defies the JSON key name as "content" or omits the key if the value is empty.
+	Name    string `json:"name,omitempty"`    // The name of the participant, to tell apart several users of a group chat (API key mode only).
+	Refusal string `json:"refusal,omitempty"` // The refusal of the assistant to answer with a response schema, instead of Content (API key mode only).
+
+	// The metadata of the messages of a conversation, set for the turns added by Ask and never sent to the API.
+	ID        string    `json:"id,omitempty"`         // The server-side ID of the message, the completion ID for API key mode answers.
+	CreatedAt time.Time `json:"created_at,omitempty"` // When the message was added to the conversation.
+}
+
+// MarshalJSON marshals the message, omitting CreatedAt if it is not set.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message // without the MarshalJSON method
+	var createdAt *time.Time
+	if !m.CreatedAt.IsZero() {
+		createdAt = &m.CreatedAt
+	}
+	return json.Marshal(struct {
+		message
+		CreatedAt *time.Time `json:"created_at,omitempty"`
+	}{message(m), createdAt})
+}
+
+// withoutMetadata returns the messages without their ID and CreatedAt, as sent to the API.
+func withoutMetadata(messages []Message) []Message {
+	stripped := make([]Message, len(messages))
+	for i, message := range messages {
+		message.ID, message.CreatedAt = "", time.Time{}
+		stripped[i] = message
+	}
+	return stripped
 }
 
 // Conversation represents a struct with three fields: InitMessage, LastMessage, and Messages.
 type Conversation struct {
 	InitMessage string    // First message sent in the conversation.
-	LastMessage string    // Most recent message sent in the conversation.
+	LastMessage string    // Most recent message in the conversation whatever its role, the assistant reply once Ask returns.
 	Messages    []Message // Slice of Message structs representing all messages sent in the conversation.
+	Engine      string    // Engine pinned for this conversation, overriding the client's engine when set.
+	Summaries   int       // Number of times the oldest messages were replaced with a summary, see Config.CompressionStrategy.
+	ParentID    string    // The ID of the last assistant message in access token mode, where the next message is attached.
+	Partial     bool      // Whether or not the last answer was cut off by a failed stream, see AskOpts.ResumePartial.
+
+	// The tree of messages fetched with GetRemoteConversation (access token mode only), updated by the following questions.
+	Tree *ConversationTree `json:",omitempty"`
+}
+
+// ConversationOpts represents the options of a conversation created with NewConversation.
+type ConversationOpts struct {
+	// The system message starting the conversation, defaults to the client's init message.
+	SystemMessage string
+	// The engine pinned for the conversation, defaults to the client's engine.
+	Engine Engine
+	// The name of a registered template rendered with Vars as the system message, overriding SystemMessage.
+	SystemTemplate string
+	// The variables of SystemTemplate.
+	Vars map[string]interface{}
+}
+
+// The default maximum number of conversations started without a conversation ID kept in memory, see Config.MaxGeneratedConversations.
+const DEFAULT_MAX_GENERATED_CONVERSATIONS = 1000
+
+// touchGenerated marks the conversation with the given ID as the most recently asked one if it was started without
+// a conversation ID, generated being true when it was just started, and evicts the least recently asked of these
+// conversations from memory beyond Config.MaxGeneratedConversations. c.mu must be held.
+func (c *Client) touchGenerated(id string, generated bool) {
+	if element, ok := c.generated[id]; ok {
+		c.generatedOrder.MoveToFront(element)
+		return
+	}
+	if !generated || c.maxGenerated < 0 {
+		return
+	}
+	c.generated[id] = c.generatedOrder.PushFront(id)
+	for c.generatedOrder.Len() > c.maxGenerated {
+		oldest := c.generatedOrder.Remove(c.generatedOrder.Back()).(string)
+		delete(c.generated, oldest)
+		delete(c.conversations, oldest)
+	}
+}
+
+// forgetGenerated stops tracking a deleted conversation started without a conversation ID. c.mu must be held.
+func (c *Client) forgetGenerated(id string) {
+	if element, ok := c.generated[id]; ok {
+		c.generatedOrder.Remove(element)
+		delete(c.generated, id)
+	}
+}
+
+// ConversationHook is called with a copy of a conversation and its ID, see Config.OnConversationCreated.
+type ConversationHook func(id string, conv Conversation)
+
+// conversationCreated calls the OnConversationCreated hook, if set, and emits an EventConversationCreated event.
+// It must be called without holding c.mu.
+func (c *Client) conversationCreated(id string, conversation Conversation) {
+	if c.onCreated != nil {
+		c.onCreated(id, conversation.clone())
+	}
+	created := conversation.clone()
+	c.emit(Event{Type: EventConversationCreated, ConversationID: id, Conversation: &created})
+}
+
+// NewConversation creates an empty conversation with a generated ID and returns the ID,
+// to be passed as AskOpts.ConversationID. It is only meaningful in API key mode, the backend creates conversations itself in access token mode.
+func (c *Client) NewConversation(opts ...ConversationOpts) (string, error) {
+	id := genUUID()
+	if id == "" {
+		return "", fmt.Errorf("failed to generate a conversation ID")
+	}
+	var options ConversationOpts
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	if options.SystemTemplate != "" {
+		systemMessage, err := c.RenderTemplate(options.SystemTemplate, options.Vars)
+		if err != nil {
+			return "", err
+		}
+		options.SystemMessage = systemMessage
+	}
+	conversation := c.newConversation(options.SystemMessage)
+	conversation.Engine = options.Engine
+
+	c.mu.Lock()
+	c.conversations[id] = conversation
+	c.mu.Unlock()
+	c.saveConversation(id, conversation)
+	return id, nil
 }
 
 // Method to add a message to the Conversation struct.
@@ -21,6 +153,12 @@ func (c *Conversation) addMessage(m Message) {
 	c.LastMessage = m.Content          // Update the LastMessage property of the Conversation with the content of the new message.
 }
 
+// Method to copy the Conversation struct, so that the copy doesn't share the Messages slice with the original.
+func (c Conversation) clone() Conversation {
+	c.Messages = append([]Message(nil), c.Messages...) // Copy the messages into a new slice.
+	return c
+}
+
 // Method to initialize the Conversation struct with an initial message.
 func (c *Conversation) initMessage(m Message) {
 	c.InitMessage = m.Content          // Set the InitMessage property of the Conversation to the content of the provided message.
@@ -28,11 +166,6 @@ func (c *Conversation) initMessage(m Message) {
 	c.Messages = append(c.Messages, m) // Append the new message to the empty Messages slice within the Conversation.
 }
 
-// Method to retrieve the number of tokens (i.e. 4-byte substrings) in the InitMessage property of the Conversation struct.
-func (c *Conversation) getTokenCount() int {
-	return len(c.InitMessage) / 4 // Return the length of the InitMessage divided by 4 to get the number of 4-byte substrings.
-}
-
 func (c *Conversation) Marshal() string {
 	// Marshal the Conversation struct to JSON.
 	json, err := json.Marshal(c)
@@ -44,18 +177,170 @@ func (c *Conversation) Marshal() string {
 	return string(json)
 }
 
-func (c *Conversation) tokenizeMessage(engine string) {
-	// Get the number of tokens in the InitMessage property of the Conversation struct.
-	tokenCount := c.getTokenCount()
+// markdownRoles are the headers of the messages in ToMarkdown, by role.
+var markdownRoles = map[string]string{
+	"system":    "System",
+	"user":      "You",
+	"assistant": "Assistant",
+}
+
+// ToMarkdown renders the conversation as Markdown, e.g. to save it to a wiki, each message under a bold header
+// of its role like **You:** or **Assistant:**. Message contents are kept as is, so code blocks are preserved;
+// a code block left open by a truncated message is closed so that it doesn't swallow the following messages.
+func (c *Conversation) ToMarkdown() string {
+	var b strings.Builder
+	for _, message := range c.Messages {
+		content := strings.TrimSpace(message.Content)
+		if content == "" && message.Refusal != "" {
+			content = "_" + strings.TrimSpace(message.Refusal) + "_"
+		}
+		if content == "" {
+			continue
+		}
+		header, ok := markdownRoles[message.Role]
+		if !ok {
+			header = message.Role
+		}
+		if message.Name != "" {
+			header += " (" + message.Name + ")"
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "**%s:**\n\n%s", header, content)
+		if strings.Count(content, "```")%2 == 1 {
+			b.WriteString("\n```")
+		}
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
 
-	// Get the maximum number of tokens allowed for the provided engine.
-	maxTokens := getEngineTokenLimit(engine)
+// Method to truncate the conversation to its InitMessage and the latest user prompt,
+// if its messages have more than budget tokens with the tokenizer of the given engine.
+// It returns true if the conversation was truncated.
+func (c *Conversation) tokenizeMessage(engine string, budget int) bool {
+	// Count the tokens of all the messages, as sent to the API.
+	if countTokens(engine, c.Messages) <= budget {
+		return false
+	}
 
-	// if the number of tokens in the message is greater than the maximum allowed, truncate the message to init_message and last_message.
-	if tokenCount > maxTokens {
-		c.Messages = []Message{
-			{Role: "system", Content: c.InitMessage},
-			{Role: "user", Content: c.LastMessage},
+	// Truncate the messages to init_message and the latest user prompt.
+	// LastMessage can't be used here since it may be an assistant reply.
+	messages := []Message{{Role: "system", Content: c.InitMessage}}
+	if prompt, ok := c.lastUserMessage(); ok {
+		messages = append(messages, prompt)
+	}
+	c.Messages = messages
+	return true
+}
+
+// Method to retrieve the most recent user message of the Conversation struct, if any.
+func (c *Conversation) lastUserMessage() (Message, bool) {
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if c.Messages[i].Role == "user" {
+			return c.Messages[i], true
 		}
 	}
+	return Message{}, false
+}
+
+// exportedConversation represents a conversation from the ChatGPT data export (conversations.json).
+type exportedConversation struct {
+	Title       string                  `json:"title"`
+	CurrentNode string                  `json:"current_node"`
+	Mapping     map[string]exportedNode `json:"mapping"`
+}
+
+// exportedNode represents a node of the message tree of an exported conversation.
+type exportedNode struct {
+	ID      string `json:"id"`
+	Parent  string `json:"parent"`
+	Message *struct {
+		Author struct {
+			Role string `json:"role"`
+			Name string `json:"name"`
+		} `json:"author"`
+		Content struct {
+			ContentType string        `json:"content_type"`
+			Parts       []interface{} `json:"parts"`
+		} `json:"content"`
+	} `json:"message"`
+}
+
+// ImportConversation imports a conversation from the ChatGPT data export, stores it under a new ID and returns that ID.
+// data is a single conversation object, or a conversations.json array containing exactly one conversation.
+// The message tree is flattened by following the current leaf up to the root.
+func (c *Client) ImportConversation(data []byte) (string, error) {
+	var exported exportedConversation
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		var list []exportedConversation
+		if err := json.Unmarshal(data, &list); err != nil {
+			return "", fmt.Errorf("invalid conversation export: %w", err)
+		}
+		if len(list) != 1 {
+			return "", fmt.Errorf("expected exactly one conversation in export, got %d", len(list))
+		}
+		exported = list[0]
+	} else if err := json.Unmarshal(data, &exported); err != nil {
+		return "", fmt.Errorf("invalid conversation export: %w", err)
+	}
+
+	// Walk from the current leaf up to the root, guarding against cycles
+	var path []exportedNode
+	visited := make(map[string]bool)
+	for id := exported.CurrentNode; id != "" && !visited[id]; {
+		node, ok := exported.Mapping[id]
+		if !ok {
+			return "", fmt.Errorf("node %s not found in conversation export", id)
+		}
+		visited[id] = true
+		path = append(path, node)
+		id = node.Parent
+	}
+	if len(path) == 0 {
+		return "", fmt.Errorf("conversation export has no current node")
+	}
+
+	// Rebuild the messages from the root down to the leaf
+	var messages []Message
+	for i := len(path) - 1; i >= 0; i-- {
+		node := path[i]
+		if node.Message == nil || node.Message.Content.ContentType != "text" {
+			continue
+		}
+		parts := make([]string, 0, len(node.Message.Content.Parts))
+		for _, part := range node.Message.Content.Parts {
+			if text, ok := part.(string); ok {
+				parts = append(parts, text)
+			}
+		}
+		content := strings.Join(parts, "\n")
+		if content == "" {
+			continue
+		}
+		messages = append(messages, Message{Role: node.Message.Author.Role, Content: content, Name: node.Message.Author.Name})
+	}
+
+	// Start the conversation with a system message, like Ask does for new conversations
+	conversation := Conversation{}
+	if len(messages) > 0 && messages[0].Role == "system" {
+		conversation.initMessage(messages[0])
+		messages = messages[1:]
+	} else {
+		initMessage := Message{Role: "system", Content: DEFAULT_INIT_MESSAGE}
+		if c.initMessage != "" {
+			initMessage.Content = c.initMessage
+		}
+		conversation.initMessage(initMessage)
+	}
+	for _, message := range messages {
+		conversation.addMessage(message)
+	}
+
+	id := genUUID()
+	c.SetConversation(id, conversation)
+	return id, nil
 }
@@ -0,0 +1,240 @@
+package chatgpt_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+// longConversation returns a conversation of the given number of turns, each message having about words tokens.
+func longConversation(turns, words int) chatgpt.Conversation {
+	text := strings.Repeat("word ", words)
+	messages := []chatgpt.Message{{Role: "system", Content: "You are a test."}}
+	for i := 0; i < turns; i++ {
+		messages = append(messages, chatgpt.Message{Role: "user", Content: text}, chatgpt.Message{Role: "assistant", Content: text})
+	}
+	var conversation chatgpt.Conversation
+	conversation.FromMessages(messages)
+	return conversation
+}
+
+func TestAskTruncatesLongConversations(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "short"}, chatgpttest.Response{Message: "again"})
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{Engine: chatgpt.EngineGPT35Turbo})
+	truncated := make(chan string, 1)
+	client.OnEvent(func(event chatgpt.Event) {
+		if event.Type == chatgpt.EventConversationTruncated {
+			truncated <- event.ConversationID
+		}
+	})
+
+	// A conversation fitting in the token limit is sent as is
+	client.SetConversation("short", longConversation(2, 100))
+	if _, err := client.Ask(context.Background(), "next", chatgpt.AskOpts{ConversationID: "short"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(server.Requests()[0].Messages()); got != 6 {
+		t.Errorf("got %d messages sent for a short conversation, want 6", got)
+	}
+
+	// Only the system message and the prompt are left of a conversation over the token limit,
+	// although its system message alone is short
+	client.SetConversation("long", longConversation(4, 500))
+	if _, err := client.Ask(context.Background(), "final question", chatgpt.AskOpts{ConversationID: "long"}); err != nil {
+		t.Fatal(err)
+	}
+	messages := server.Requests()[1].Messages()
+	if len(messages) != 2 || messages[0] != [2]string{"system", "You are a test."} || messages[1] != [2]string{"user", "final question"} {
+		t.Errorf("got messages %q, want the system message and the prompt", messages)
+	}
+	select {
+	case id := <-truncated:
+		if id != "long" {
+			t.Errorf("got a truncation event for %q", id)
+		}
+	case <-time.After(time.Second):
+		t.Error("no truncation event")
+	}
+}
+
+func TestAskEvictsGeneratedConversations(t *testing.T) {
+	server := chatgpttest.NewServer()
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{MaxGeneratedConversations: 2})
+	named, err := client.NewConversation()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ask := func(opts ...chatgpt.AskOpts) string {
+		t.Helper()
+		server.AddResponses(chatgpttest.Response{Message: "Hi"})
+		response, err := client.Ask(context.Background(), "Hello", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.ConversationID
+	}
+	first, second := ask(), ask()
+	ask(chatgpt.AskOpts{ConversationID: first}) // the second one is now the least recently asked
+	third := ask()
+	ask(chatgpt.AskOpts{ConversationID: named})
+
+	if client.HasConversation(second) {
+		t.Error("the least recently asked generated conversation was kept")
+	}
+	for _, id := range []string{first, third, named} {
+		if !client.HasConversation(id) {
+			t.Errorf("conversation %s was evicted", id)
+		}
+	}
+	if count := client.ConversationCount(); count != 3 {
+		t.Errorf("got %d conversations, want 3", count)
+	}
+}
+
+func TestStatelessAskDoesNotReportCreated(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "one-off"}, chatgpttest.Response{Message: "kept"})
+	defer server.Close()
+	var hooked []string
+	client := startApiKeyClient(t, server, chatgpt.Config{
+		StatelessAsk:          true,
+		OnConversationCreated: func(id string, _ chatgpt.Conversation) { hooked = append(hooked, id) },
+	})
+	created := make(chan string, 2)
+	client.OnEvent(func(event chatgpt.Event) {
+		if event.Type == chatgpt.EventConversationCreated {
+			created <- event.ConversationID
+		}
+	})
+
+	if _, err := client.Ask(context.Background(), "Hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Ask(context.Background(), "Hello", chatgpt.AskOpts{ConversationID: "kept"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(hooked) != 1 || hooked[0] != "kept" {
+		t.Errorf("got OnConversationCreated calls for %q, want only the kept conversation", hooked)
+	}
+	// The events are dispatched in order, so the one-off conversation would come first
+	select {
+	case id := <-created:
+		if id != "kept" {
+			t.Errorf("got a created event for %q, want only the kept conversation", id)
+		}
+	case <-time.After(time.Second):
+		t.Error("got no created event for the kept conversation")
+	}
+}
+
+func TestMessageMetadataJSON(t *testing.T) {
+	// Messages without metadata are encoded as before
+	data, err := json.Marshal(chatgpt.Message{Role: "user", Content: "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"role":"user","content":"Hi"}` {
+		t.Errorf("got %s without metadata", data)
+	}
+
+	message := chatgpt.Message{Role: "assistant", Content: "Hello", ID: "chatcmpl-1", CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)}
+	if data, err = json.Marshal(message); err != nil {
+		t.Fatal(err)
+	}
+	var decoded chatgpt.Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != message.ID || !decoded.CreatedAt.Equal(message.CreatedAt) || decoded.Content != message.Content {
+		t.Errorf("got %+v from %s, want %+v", decoded, data, message)
+	}
+}
+
+func TestAskRecordsMessageMetadata(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Hello"})
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{})
+
+	before := time.Now()
+	response, err := client.Ask(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation, err := client.GetConversation(response.ConversationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := conversation.Messages
+	if len(messages) != 3 {
+		t.Fatalf("got messages %+v", messages)
+	}
+	question, answer := messages[1], messages[2]
+	if question.CreatedAt.Before(before) || answer.CreatedAt.Before(question.CreatedAt) {
+		t.Errorf("got timestamps %s and %s", question.CreatedAt, answer.CreatedAt)
+	}
+	if answer.ID != "chatcmpl-1" {
+		t.Errorf("got answer ID %q, want the completion ID", answer.ID)
+	}
+	// The metadata is never sent to the API
+	if _, ok := server.Requests()[0].Body["messages"].([]interface{})[1].(map[string]interface{})["created_at"]; ok {
+		t.Error("the metadata of the messages was sent")
+	}
+}
+
+func TestAskSummarizesLongConversations(t *testing.T) {
+	server := chatgpttest.NewServer(
+		chatgpttest.Response{Message: "The user repeated a word."}, chatgpttest.Response{Message: "summarized"},
+		chatgpttest.Response{Message: "truncated"},
+	)
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{
+		Engine:              chatgpt.EngineGPT35Turbo,
+		CompressionStrategy: chatgpt.CompressionSummarize,
+		CompressionMaxRuns:  1,
+	})
+
+	// The turns between the system message and the prompt are replaced with a summary asked first
+	client.SetConversation("long", longConversation(4, 500))
+	if _, err := client.Ask(context.Background(), "final question", chatgpt.AskOpts{ConversationID: "long"}); err != nil {
+		t.Fatal(err)
+	}
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want the summary and the question", len(requests))
+	}
+	summary := requests[0].Messages()
+	if len(summary) != 2 || summary[0][0] != "system" || !strings.HasPrefix(summary[1][1], "user: word word") {
+		t.Errorf("got summary request %q", summary)
+	}
+	want := [][2]string{
+		{"system", "You are a test."},
+		{"system", "Previous conversation summary: The user repeated a word."},
+		{"user", "final question"},
+	}
+	if got := requests[1].Messages(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+	conversation, _ := client.GetConversation("long")
+	if conversation.Summaries != 1 || len(conversation.Messages) != 4 || conversation.LastMessage != "summarized" {
+		t.Errorf("got conversation %+v", conversation)
+	}
+
+	// Once CompressionMaxRuns is reached, the conversation is truncated instead
+	long := longConversation(4, 500)
+	long.Summaries = 1
+	client.SetConversation("summarized", long)
+	if _, err := client.Ask(context.Background(), "final question", chatgpt.AskOpts{ConversationID: "summarized"}); err != nil {
+		t.Fatal(err)
+	}
+	requests = server.Requests()
+	if len(requests) != 3 || len(requests[2].Messages()) != 2 {
+		t.Errorf("got %d requests, want a single truncated one", len(requests)-2)
+	}
+}
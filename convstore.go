@@ -0,0 +1,145 @@
+package chatgpt
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// ConversationStore is the durable storage of conversations, see Config.ConversationStore.
+// The client keeps its in-memory conversations as a cache in front of it: conversations are saved
+// after each turn and read from the store when they are not in memory, e.g. after a restart.
+// Implementations must be safe for concurrent use.
+type ConversationStore interface {
+	// Load returns the conversation with the given ID, or an error wrapping ErrConversationNotFound if there is none.
+	Load(id string) (Conversation, error)
+	// Save creates or replaces the conversation with the given ID.
+	Save(id string, conv Conversation) error
+	// Delete removes the conversation with the given ID, it is not an error if there is none.
+	Delete(id string) error
+	// List returns the IDs of all stored conversations.
+	List() ([]string, error)
+}
+
+// The table used by NewSQLConversationStore when no table name is given.
+const DEFAULT_CONVERSATIONS_TABLE = "chatgpt_conversations"
+
+// tableName matches the table names accepted by NewSQLConversationStore, since they can't be query parameters.
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLConversationStore is a ConversationStore keeping one row per conversation in a database/sql database,
+// with the conversation encoded as JSON. The queries are written for SQLite, import a driver such as
+// github.com/mattn/go-sqlite3 or modernc.org/sqlite to use it.
+type SQLConversationStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLConversationStore returns a store of conversations in db, creating its table if it doesn't exist.
+// The table name defaults to DEFAULT_CONVERSATIONS_TABLE.
+func NewSQLConversationStore(db *sql.DB, table ...string) (*SQLConversationStore, error) {
+	name := DEFAULT_CONVERSATIONS_TABLE
+	if len(table) > 0 && table[0] != "" {
+		name = table[0]
+	}
+	if !tableName.MatchString(name) {
+		return nil, fmt.Errorf("invalid table name %q", name)
+	}
+	query := "CREATE TABLE IF NOT EXISTS " + name + " (id TEXT PRIMARY KEY, data TEXT NOT NULL, updated_at TIMESTAMP NOT NULL)"
+	if _, err := db.Exec(query); err != nil {
+		return nil, fmt.Errorf("failed to create the conversations table: %w", err)
+	}
+	return &SQLConversationStore{db: db, table: name}, nil
+}
+
+// Load returns the conversation with the given ID.
+func (s *SQLConversationStore) Load(id string) (Conversation, error) {
+	var data string
+	err := s.db.QueryRow("SELECT data FROM "+s.table+" WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Conversation{}, fmt.Errorf("%w: %s", ErrConversationNotFound, id)
+	} else if err != nil {
+		return Conversation{}, err
+	}
+	var conv Conversation
+	if err := json.Unmarshal([]byte(data), &conv); err != nil {
+		return Conversation{}, fmt.Errorf("invalid stored conversation %s: %w", id, err)
+	}
+	return conv, nil
+}
+
+// Save creates or replaces the conversation with the given ID.
+func (s *SQLConversationStore) Save(id string, conv Conversation) error {
+	data, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT INTO "+s.table+" (id, data, updated_at) VALUES (?, ?, ?) "+
+		"ON CONFLICT(id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at", id, string(data), time.Now().UTC())
+	return err
+}
+
+// Delete removes the conversation with the given ID.
+func (s *SQLConversationStore) Delete(id string) error {
+	_, err := s.db.Exec("DELETE FROM "+s.table+" WHERE id = ?", id)
+	return err
+}
+
+// List returns the IDs of all stored conversations, the most recently updated first.
+func (s *SQLConversationStore) List() ([]string, error) {
+	rows, err := s.db.Query("SELECT id FROM " + s.table + " ORDER BY updated_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// cachedConversation returns the conversation with the given ID from memory, or from the conversation store
+// on a cache miss, in which case it is added to memory. It must be called without holding c.mu.
+func (c *Client) cachedConversation(id string) (Conversation, bool) {
+	c.mu.RLock()
+	conv, ok := c.conversations[id]
+	c.mu.RUnlock()
+	if ok || c.convStore == nil || id == "" {
+		return conv, ok
+	}
+
+	// Load outside the lock, a conversation created meanwhile takes precedence
+	stored, err := c.convStore.Load(id)
+	if err != nil {
+		if !errors.Is(err, ErrConversationNotFound) {
+			c.logger.Warnf("Failed to load conversation %s: %v", id, err)
+		}
+		return Conversation{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conv, ok := c.conversations[id]; ok {
+		return conv, true
+	}
+	c.conversations[id] = stored
+	return stored, true
+}
+
+// saveConversation writes a conversation through to the conversation store, if any.
+// Failures are logged, the conversation is still in memory. It must be called without holding c.mu.
+func (c *Client) saveConversation(id string, conv Conversation) {
+	if c.convStore == nil {
+		return
+	}
+	if err := c.convStore.Save(id, conv); err != nil {
+		c.logger.Warnf("Failed to save conversation %s: %v", id, err)
+	}
+}
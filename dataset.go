@@ -0,0 +1,92 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// FromMessages replaces the messages of the conversation with the given OpenAI API messages array,
+// e.g. a line of a fine-tuning dataset. A leading system message becomes the InitMessage.
+// The access token mode ParentID and Tree, which no longer match the messages, are cleared.
+// Store the conversation with Client.SetConversation to continue it with Ask.
+func (c *Conversation) FromMessages(messages []Message) {
+	c.InitMessage = ""
+	c.LastMessage = ""
+	c.ParentID = ""
+	c.Tree = nil
+	c.Messages = make([]Message, 0, len(messages))
+	for i, message := range messages {
+		if i == 0 && message.Role == "system" {
+			c.initMessage(message)
+			continue
+		}
+		c.addMessage(message)
+	}
+}
+
+// ExportFilter selects the conversations and messages written by ExportConversationsJSONLWithFilter.
+// A nil function keeps everything.
+type ExportFilter struct {
+	// Conversation returns false to exclude a conversation.
+	Conversation func(id string, conv *Conversation) bool
+	// Message returns false to exclude a message of a conversation, e.g. an errored or refused turn.
+	Message func(id string, message Message) bool
+}
+
+// datasetLine is a conversation in the fine-tuning JSONL format.
+type datasetLine struct {
+	Messages []datasetMessage `json:"messages"`
+}
+
+// datasetMessage is a message in the fine-tuning JSONL format, without the metadata of Message.
+type datasetMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Name    string `json:"name,omitempty"`
+}
+
+// ExportConversationsJSONL writes the conversations with the given IDs, or all of them sorted by ID if none is given,
+// to w in the fine-tuning JSONL format: one {"messages": [...]} line per conversation, with the system, user and
+// assistant roles preserved and the other message metadata stripped. Messages without content, like refusals, are skipped.
+func (c *Client) ExportConversationsJSONL(w io.Writer, ids ...string) error {
+	return c.ExportConversationsJSONLWithFilter(w, ExportFilter{}, ids...)
+}
+
+// ExportConversationsJSONLWithFilter is like ExportConversationsJSONL, but only writes the conversations and messages
+// kept by filter. Conversations left without messages are not written.
+func (c *Client) ExportConversationsJSONLWithFilter(w io.Writer, filter ExportFilter, ids ...string) error {
+	if len(ids) == 0 {
+		for id := range c.GetConversations() {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, id := range ids {
+		conversation, err := c.GetConversation(id)
+		if err != nil {
+			return err
+		}
+		if filter.Conversation != nil && !filter.Conversation(id, conversation) {
+			continue
+		}
+		line := datasetLine{Messages: make([]datasetMessage, 0, len(conversation.Messages))}
+		for _, message := range conversation.Messages {
+			if message.Content == "" || (filter.Message != nil && !filter.Message(id, message)) {
+				continue
+			}
+			line.Messages = append(line.Messages, datasetMessage{Role: message.Role, Content: message.Content, Name: message.Name})
+		}
+		if len(line.Messages) == 0 {
+			continue
+		}
+		if err := encoder.Encode(line); err != nil {
+			return fmt.Errorf("failed to write conversation %s: %w", id, err)
+		}
+	}
+	return nil
+}
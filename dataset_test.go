@@ -0,0 +1,96 @@
+package chatgpt_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+const dataset = `{"messages":[{"role":"system","content":"You are terse."},{"role":"user","content":"Hi <there>","name":"ann"},{"role":"assistant","content":"Hello & welcome"}]}
+{"messages":[{"role":"user","content":"What is 2+2?"},{"role":"assistant","content":"4"}]}
+`
+
+// importDataset stores the conversations of a JSONL dataset as "line-1", "line-2"...
+func importDataset(t *testing.T, client *chatgpt.Client, data string) {
+	t.Helper()
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for i := 1; scanner.Scan(); i++ {
+		var line struct {
+			Messages []chatgpt.Message `json:"messages"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		// The metadata is stripped by the export
+		for j := range line.Messages {
+			line.Messages[j].ID = "id"
+			line.Messages[j].CreatedAt = time.Now()
+		}
+		var conversation chatgpt.Conversation
+		conversation.FromMessages(line.Messages)
+		client.SetConversation("line-"+strconv.Itoa(i), conversation)
+	}
+}
+
+func TestDatasetRoundTrip(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Bye"})
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{})
+	importDataset(t, client, dataset)
+
+	conversation, err := client.GetConversation("line-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conversation.InitMessage != "You are terse." || len(conversation.Messages) != 3 {
+		t.Errorf("got imported conversation %+v", conversation)
+	}
+
+	var exported bytes.Buffer
+	if err := client.ExportConversationsJSONL(&exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.String() != dataset {
+		t.Errorf("got export\n%s\nwant\n%s", exported.String(), dataset)
+	}
+
+	// An imported conversation is continued with its history
+	if _, err := client.Ask(context.Background(), "Bye", chatgpt.AskOpts{ConversationID: "line-1"}); err != nil {
+		t.Fatal(err)
+	}
+	messages := server.Requests()[0].Messages()
+	if len(messages) != 4 || messages[0] != [2]string{"system", "You are terse."} || messages[3] != [2]string{"user", "Bye"} {
+		t.Errorf("got messages %q", messages)
+	}
+}
+
+func TestDatasetExportFilter(t *testing.T) {
+	server := chatgpttest.NewServer()
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{})
+	importDataset(t, client, dataset)
+
+	var exported bytes.Buffer
+	err := client.ExportConversationsJSONLWithFilter(&exported, chatgpt.ExportFilter{
+		Conversation: func(id string, _ *chatgpt.Conversation) bool { return id == "line-2" },
+		Message:      func(_ string, message chatgpt.Message) bool { return message.Role != "assistant" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"messages":[{"role":"user","content":"What is 2+2?"}]}` + "\n"; exported.String() != want {
+		t.Errorf("got %q, want %q", exported.String(), want)
+	}
+
+	if err := client.ExportConversationsJSONL(&exported, "unknown"); err == nil {
+		t.Error("got no error for an unknown conversation")
+	}
+}
@@ -0,0 +1,114 @@
+package chatgpt
+
+import (
+	"strings"
+	"sync"
+)
+
+// Engine is the name of a GPT model. It is an alias of string, so that raw model names keep working
+// for models without a constant.
+type Engine = string
+
+// The common engines, for use in Config.Engine, SetEngine and ConversationOpts.Engine.
+const (
+	EngineGPT35Turbo    Engine = "gpt-3.5-turbo"                // The default engine in API key mode.
+	EngineGPT35Turbo16k Engine = "gpt-3.5-turbo-16k"            // gpt-3.5-turbo with a 16k tokens context.
+	EngineGPT4          Engine = "gpt-4"                        // gpt-4 with an 8k tokens context.
+	EngineGPT4Turbo     Engine = "gpt-4-turbo"                  // gpt-4 turbo with a 128k tokens context.
+	EngineGPT432k       Engine = "gpt-4-32k"                    // gpt-4 with a 32k tokens context.
+	EngineGPT4o         Engine = "gpt-4o"                       // gpt-4o with a 128k tokens context and structured outputs.
+	EngineGPT4oMini     Engine = "gpt-4o-mini"                  // The small gpt-4o model.
+	EngineO1            Engine = "o1"                           // The o1 reasoning model, see modelCapabilities.
+	EngineO1Mini        Engine = "o1-mini"                      // The small o1 reasoning model, without system messages.
+	EngineO3Mini        Engine = "o3-mini"                      // The small o3 reasoning model.
+	EngineChatGPTFree   Engine = "text-davinci-002-render-sha"  // The engine of free accounts in access token mode.
+	EngineChatGPTPaid   Engine = "text-davinci-002-render-paid" // The legacy engine of paid accounts in access token mode.
+)
+
+// knownEngines is the set of engines accepted without a warning, see RegisterEngine.
+var knownEngines = map[string]bool{
+	// API key mode
+	EngineGPT35Turbo:         true,
+	"gpt-3.5-turbo-0301":     true,
+	"gpt-3.5-turbo-0613":     true,
+	EngineGPT35Turbo16k:      true,
+	"gpt-3.5-turbo-16k-0613": true,
+	EngineGPT4:               true,
+	"gpt-4-0314":             true,
+	"gpt-4-0613":             true,
+	EngineGPT4Turbo:          true,
+	EngineGPT432k:            true,
+	"gpt-4-32k-0314":         true,
+	"gpt-4-32k-0613":         true,
+	EngineGPT4o:              true,
+	EngineGPT4oMini:          true,
+	EngineO1:                 true,
+	EngineO1Mini:             true,
+	"o1-preview":             true,
+	"o3":                     true,
+	EngineO3Mini:             true,
+	// Access token mode
+	EngineChatGPTFree:        true,
+	EngineChatGPTPaid:        true,
+	"gpt-4-browsing":         true,
+	"gpt-4-plugins":          true,
+	"gpt-4-code-interpreter": true,
+}
+
+// knownEnginesMu guards knownEngines.
+var knownEnginesMu sync.RWMutex
+
+// RegisterEngine adds engines to the set of known engines, so that using them doesn't log a warning.
+// Use it for models released after this version of the package, or set Config.AllowUnknownEngine to skip the check altogether.
+func RegisterEngine(engines ...string) {
+	knownEnginesMu.Lock()
+	defer knownEnginesMu.Unlock()
+	for _, engine := range engines {
+		knownEngines[engine] = true
+	}
+}
+
+// IsKnownEngine returns true if the engine is a known or registered one.
+func IsKnownEngine(engine string) bool {
+	knownEnginesMu.RLock()
+	defer knownEnginesMu.RUnlock()
+	return knownEngines[engine]
+}
+
+// checkEngine logs a warning if the engine is unknown, unless unknown engines are allowed.
+// Requests with a model the API doesn't serve fail with a generic error, this catches typos early.
+func (c *Client) checkEngine(engine string) {
+	if c.anyEngine || IsKnownEngine(engine) {
+		return
+	}
+	c.logger.Warnf("Unknown engine %s, requests may fail with a model not found error (see RegisterEngine or Config.AllowUnknownEngine)", engine)
+}
+
+// modelCapabilities describes how the payload of a model family differs from the chat models one.
+type modelCapabilities struct {
+	maxCompletionTokens bool   // Whether or not max_tokens must be sent as max_completion_tokens.
+	noSampling          bool   // Whether or not the sampling parameters (temperature, top_p, penalties, logit_bias) are rejected.
+	systemRole          string // The role system messages are sent as, "user" merges them into the next user message.
+	structuredOutputs   bool   // Whether or not a "json_schema" response format is supported.
+}
+
+// reasoningCapabilities is the table of model capabilities keyed by engine prefix, the longest matching prefix wins.
+// Engines without a matching prefix use the chat models payload.
+var reasoningCapabilities = map[string]modelCapabilities{
+	"o1":         {maxCompletionTokens: true, noSampling: true, systemRole: "developer", structuredOutputs: true},
+	"o1-mini":    {maxCompletionTokens: true, noSampling: true, systemRole: "user"},
+	"o1-preview": {maxCompletionTokens: true, noSampling: true, systemRole: "user"},
+	"o3":         {maxCompletionTokens: true, noSampling: true, systemRole: "developer", structuredOutputs: true},
+	"o4":         {maxCompletionTokens: true, noSampling: true, systemRole: "developer", structuredOutputs: true},
+}
+
+// capabilitiesForEngine returns the capabilities of engine, ok is false for chat models.
+func capabilitiesForEngine(engine string) (capabilities modelCapabilities, ok bool) {
+	match := ""
+	for prefix, value := range reasoningCapabilities {
+		if strings.HasPrefix(engine, prefix) && len(prefix) > len(match) {
+			match, capabilities, ok = prefix, value, true
+		}
+	}
+	return capabilities, ok
+}
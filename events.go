@@ -0,0 +1,116 @@
+package chatgpt
+
+import "time"
+
+// EventType is the type of an Event.
+type EventType string
+
+// The types of the events emitted to the handlers registered with OnEvent.
+const (
+	EventConversationCreated   EventType = "conversation_created"   // A conversation was created by Ask, Conversation holds it.
+	EventMessageAdded          EventType = "message_added"          // A message was added to a conversation, Message holds it.
+	EventConversationTruncated EventType = "conversation_truncated" // A conversation was truncated or summarized to fit the token limit.
+	EventTokenRefreshed        EventType = "token_refreshed"        // A new access token was obtained with email and password.
+	EventRequestFailed         EventType = "request_failed"         // A request to OpenAI failed, Err holds the error.
+	EventRequestCompleted      EventType = "request_completed"      // A request to OpenAI got a response or failed to, see StatusCode and Duration.
+	EventCacheLookup           EventType = "cache_lookup"           // The response or access token cache was looked up, see Cache and Hit.
+	EventStreamStarted         EventType = "stream_started"         // A streamed response was opened.
+	EventStreamEnded           EventType = "stream_ended"           // A streamed response was closed or cancelled.
+)
+
+// The caches reported by EventCacheLookup events.
+const (
+	CacheResponse = "response" // The response cache of API key mode, see Config.ResponseCache.
+	CacheToken    = "token"    // The access token cache, looked up on Start.
+)
+
+// The number of events queued for the handlers before new events are dropped, see OnEvent.
+const DEFAULT_EVENT_QUEUE_SIZE = 256
+
+// Event is a change in the client state, passed to the handlers registered with OnEvent.
+// Only the fields relevant to its type are set.
+type Event struct {
+	Type             EventType     // The type of the event.
+	ConversationID   string        // The ID of the conversation the event is about, if any.
+	Conversation     *Conversation // A copy of the created conversation, for EventConversationCreated.
+	Message          *Message      // The added message, for EventMessageAdded.
+	Err              error         // The error of the failed request, for EventRequestFailed.
+	Mode             string        // The auth mode of the request, AuthModeApiKey or AuthModeAccessToken, for EventRequestCompleted.
+	Engine           string        // The engine of the request, for EventRequestCompleted.
+	StatusCode       int           // The HTTP status code of the response, 0 if none was received, for EventRequestCompleted.
+	Duration         time.Duration // How long the request took, until the response headers for streams, for EventRequestCompleted.
+	PromptTokens     int           // The prompt tokens used by a successful API key mode request, for EventRequestCompleted.
+	CompletionTokens int           // The completion tokens used by a successful API key mode request, for EventRequestCompleted.
+	Cache            string        // The looked up cache, CacheResponse or CacheToken, for EventCacheLookup.
+	Hit              bool          // Whether or not the cache had the entry, for EventCacheLookup.
+	Time             time.Time     // When the event happened.
+}
+
+// OnEvent registers a handler called with each event of the client, e.g. to update a dashboard or export metrics.
+// Events are queued without blocking the client and handlers are called one at a time from a separate goroutine,
+// in the order the events happened. When slow handlers let the queue fill up, new events are dropped with a warning.
+func (c *Client) OnEvent(handler func(Event)) {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+	c.handlers = append(c.handlers, handler)
+	if c.events == nil {
+		c.events = make(chan Event, DEFAULT_EVENT_QUEUE_SIZE)
+		go c.dispatchEvents(c.events)
+	}
+}
+
+// emit queues an event for the registered handlers, dropping it if the queue is full.
+func (c *Client) emit(event Event) {
+	c.eventsMu.RLock()
+	defer c.eventsMu.RUnlock()
+	if c.events == nil {
+		return
+	}
+	event.Time = time.Now()
+	select {
+	case c.events <- event:
+	default:
+		c.logger.Warnf("Event queue is full, dropping %s event", event.Type)
+	}
+}
+
+// dispatchEvents calls the registered handlers with each queued event.
+func (c *Client) dispatchEvents(events chan Event) {
+	for event := range events {
+		c.eventsMu.RLock()
+		handlers := c.handlers
+		c.eventsMu.RUnlock()
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+// emitMessage emits an EventMessageAdded event for a message added to a conversation.
+func (c *Client) emitMessage(id string, message Message) {
+	c.emit(Event{Type: EventMessageAdded, ConversationID: id, Message: &message})
+}
+
+// emitFailure emits an EventRequestFailed event for a failed request and returns err.
+func (c *Client) emitFailure(err error) error {
+	if err != nil {
+		c.emit(Event{Type: EventRequestFailed, Err: err})
+	}
+	return err
+}
+
+// emitRequest emits an EventRequestCompleted event for a request sent at start, status is 0 if it failed without a response.
+// The token usage is only known for non streamed API key mode responses.
+func (c *Client) emitRequest(engine string, status int, start time.Time, promptTokens, completionTokens int) {
+	mode := AuthModeApiKey
+	if c.authmode == AccessTokenMode {
+		mode = AuthModeAccessToken
+	}
+	c.emit(Event{Type: EventRequestCompleted, Mode: mode, Engine: engine, StatusCode: status, Duration: time.Since(start),
+		PromptTokens: promptTokens, CompletionTokens: completionTokens})
+}
+
+// emitCacheLookup emits an EventCacheLookup event for a lookup of the given cache.
+func (c *Client) emitCacheLookup(cache string, hit bool) {
+	c.emit(Event{Type: EventCacheLookup, Cache: cache, Hit: hit})
+}
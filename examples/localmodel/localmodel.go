@@ -0,0 +1,28 @@
+package examples
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amarnathcjd/chatgpt"
+)
+
+// Talk to a local model served by Ollama or LM Studio through their OpenAI compatible API.
+func main() {
+	chatgpt.OPENAI_HOST = "http://localhost:11434/v1/chat/completions"
+	gpt := chatgpt.NewClient(&chatgpt.Config{
+		ApiKey:             "ollama", // any key, local servers don't check it
+		Engine:             "llama3",
+		AllowUnknownEngine: true,
+	})
+	if err := gpt.Start(); err != nil {
+		panic(err)
+	}
+
+	// API key mode doesn't stream yet, the response is printed at once when it is complete.
+	response, err := gpt.Ask(context.Background(), "Hello")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(response.Message)
+}
@@ -0,0 +1,145 @@
+package chatgpt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The kinds of the attachments of an access token mode response.
+const (
+	AttachmentImage    = "image"    // An image generated by the model, e.g. with DALL-E, download it with DownloadFile.
+	AttachmentCitation = "citation" // A web page cited by the model while browsing.
+)
+
+// Attachment is an image or a citation referenced by an assistant message in access token mode, see ChatResponse.Attachments.
+type Attachment struct {
+	Kind   string `json:"kind"`              // AttachmentImage or AttachmentCitation.
+	FileID string `json:"file_id,omitempty"` // The file service ID of an image, see DownloadFile.
+	URL    string `json:"url,omitempty"`     // The URL of a cited page.
+	Title  string `json:"title,omitempty"`   // The title of a cited page, or the prompt of a generated image.
+}
+
+// messageContent returns the text and the image attachments of the content of a backend message,
+// ok is false if its content type is not supported.
+func messageContent(content map[string]interface{}) (text string, attachments []Attachment, ok bool) {
+	parts, _ := content["parts"].([]interface{})
+	switch content["content_type"] {
+	case "text":
+		if len(parts) > 0 && parts[0] != nil {
+			text = fmt.Sprintf("%v", parts[0])
+		}
+		return text, nil, true
+	case "multimodal_text":
+		var texts []string
+		for _, part := range parts {
+			switch part := part.(type) {
+			case string:
+				texts = append(texts, part)
+			case map[string]interface{}:
+				if image, ok := imageAttachment(part); ok {
+					attachments = append(attachments, image)
+				}
+			}
+		}
+		return strings.Join(texts, "\n"), attachments, true
+	default:
+		return "", nil, false
+	}
+}
+
+// imageAttachment returns the attachment of an image asset pointer part of a multimodal message.
+func imageAttachment(part map[string]interface{}) (Attachment, bool) {
+	pointer, _ := part["asset_pointer"].(string)
+	if part["content_type"] != "image_asset_pointer" || pointer == "" {
+		return Attachment{}, false
+	}
+	image := Attachment{Kind: AttachmentImage, FileID: pointer}
+	if i := strings.Index(pointer, "://"); i >= 0 {
+		image.FileID = pointer[i+3:]
+	}
+	if metadata, ok := part["metadata"].(map[string]interface{}); ok {
+		if dalle, ok := metadata["dalle"].(map[string]interface{}); ok {
+			image.Title, _ = dalle["prompt"].(string)
+		}
+	}
+	return image, true
+}
+
+// messageCitations returns the citations in the metadata of a backend message.
+func messageCitations(messageData map[string]interface{}) []Attachment {
+	metadata, _ := messageData["metadata"].(map[string]interface{})
+	citations, _ := metadata["citations"].([]interface{})
+	var attachments []Attachment
+	for _, citation := range citations {
+		citation, _ := citation.(map[string]interface{})
+		details, _ := citation["metadata"].(map[string]interface{})
+		url, _ := details["url"].(string)
+		if url == "" {
+			continue
+		}
+		title, _ := details["title"].(string)
+		attachments = append(attachments, Attachment{Kind: AttachmentCitation, URL: url, Title: title})
+	}
+	return attachments
+}
+
+// mergeAttachments appends the attachments not already in attachments, images by file ID and citations by URL.
+func mergeAttachments(attachments []Attachment, added ...Attachment) []Attachment {
+	for _, attachment := range added {
+		found := false
+		for _, existing := range attachments {
+			if existing.Kind == attachment.Kind && existing.FileID == attachment.FileID && existing.URL == attachment.URL {
+				found = true
+				break
+			}
+		}
+		if !found {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+// DownloadFile writes the file with the given file service ID to w, e.g. an image from ChatResponse.Attachments.
+// The download URL is resolved with the access token, only supported in access token mode.
+func (c *Client) DownloadFile(ctx context.Context, fileID string, w io.Writer) error {
+	if err := c.checkAccessTokenMode("file downloads"); err != nil {
+		return err
+	}
+	if fileID == "" {
+		return fmt.Errorf("no file ID given")
+	}
+
+	var download struct {
+		Status      string `json:"status"`
+		DownloadURL string `json:"download_url"`
+		ErrorCode   string `json:"error_code"`
+	}
+	if err := c.backendRequest(ctx, "GET", "files/"+fileID+"/download", nil, &download); err != nil {
+		return err
+	}
+	if download.DownloadURL == "" {
+		return fmt.Errorf("no download URL for file %s (status %s %s)", fileID, download.Status, download.ErrorCode)
+	}
+
+	// The download URL is signed, it doesn't need the access token
+	req, err := http.NewRequestWithContext(ctx, "GET", download.DownloadURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid download URL for file %s: %w", fileID, err)
+	}
+	c.setCustomHeaders(req)
+	resp, err := c.httpx.Do(req)
+	if err != nil {
+		return fmt.Errorf("system error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return &ChatError{Message: string(body), Code: resp.StatusCode, RetryAfter: retryAfter(resp.Header)}
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
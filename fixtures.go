@@ -0,0 +1,127 @@
+package chatgpt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fixture is a recorded request/response pair, stored as JSON in the fixtures directory.
+type fixture struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"` // The raw response body, including SSE event streams.
+}
+
+// fixtureTransport records request/response pairs to a directory, or replays them without network access.
+// Requests are matched on their method, URL and a hash of their body, without its generated IDs.
+type fixtureTransport struct {
+	// The transport used to send requests when recording.
+	next http.RoundTripper
+	// The directory where fixtures are recorded to or replayed from.
+	dir string
+	// Whether or not to replay fixtures instead of recording them.
+	replay bool
+	// secrets returns the values redacted from recorded responses.
+	secrets func() []string
+}
+
+// RoundTrip records or replays the given request.
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	path := filepath.Join(t.dir, fixtureKey(req.Method, req.URL.String(), body)+".json")
+
+	if t.replay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("no fixture for %s %s: %w", req.Method, req.URL, err)
+		}
+		var recorded fixture
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+			StatusCode:    recorded.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        recorded.Header,
+			Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+			ContentLength: int64(len(recorded.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// Read the whole body, so that streamed responses are recorded as raw event stream bytes
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	recorded := fixture{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header.Clone(),
+		Body:   t.redact(string(respBody)),
+	}
+	recorded.Header.Del("Set-Cookie")
+	data, _ := json.MarshalIndent(recorded, "", "  ")
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// redact replaces the client's secrets in the given text.
+func (t *fixtureTransport) redact(text string) string {
+	if t.secrets == nil {
+		return text
+	}
+	for _, secret := range t.secrets() {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, "REDACTED")
+		}
+	}
+	return text
+}
+
+// uuidPattern matches the IDs generated for each request, such as the message IDs of access token payloads.
+var uuidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// fixtureKey returns the file name of the fixture matching the given method, URL and body.
+// The UUIDs of the body are ignored, since the same request has new ones each time it is sent.
+func fixtureKey(method, url string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + url + "\n"))
+	hash.Write(uuidPattern.ReplaceAll(body, []byte("uuid")))
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
@@ -0,0 +1,66 @@
+package chatgpt_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+// askBoth asks a question in API key mode and streams one in access token mode, returning both answers.
+func askBoth(t *testing.T, server *chatgpttest.Server, config chatgpt.Config) (string, string) {
+	t.Helper()
+	apiClient := startApiKeyClient(t, server, config)
+	response, err := apiClient.Ask(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenClient := startAccessTokenClient(t, server, config)
+	ch, err := tokenClient.AskStream(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed string
+	for message := range ch {
+		if message.Err != nil {
+			t.Fatal(message.Err)
+		}
+		streamed = message.Message
+	}
+	return response.Message, streamed
+}
+
+func TestRecordAndReplayFixtures(t *testing.T) {
+	dir := t.TempDir()
+	server := chatgpttest.NewServer(
+		chatgpttest.Response{Message: "Recorded answer"},
+		chatgpttest.Response{Message: "Streamed answer", ConversationID: "c1"},
+	)
+	answer, streamed := askBoth(t, server, chatgpt.Config{RecordFixtures: dir})
+	if answer != "Recorded answer" || streamed != "Streamed answer" {
+		t.Fatalf("got %q and %q while recording", answer, streamed)
+	}
+	recorded := len(server.Requests())
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) < 2 {
+		t.Fatalf("got %d fixtures and error %v", len(entries), err)
+	}
+
+	// The fixtures are replayed without the server, although the access token payload has new message IDs
+	server.Close()
+	answer, streamed = askBoth(t, server, chatgpt.Config{ReplayFixtures: dir})
+	if answer != "Recorded answer" || streamed != "Streamed answer" {
+		t.Errorf("got %q and %q while replaying", answer, streamed)
+	}
+	if got := len(server.Requests()); got != recorded {
+		t.Errorf("got %d requests to the server while replaying", got-recorded)
+	}
+
+	// Unrecorded requests fail instead of reaching the network
+	client := startApiKeyClient(t, server, chatgpt.Config{ReplayFixtures: dir})
+	if _, err := client.Ask(context.Background(), "Something else"); err == nil {
+		t.Error("got no error for a request without fixture")
+	}
+}
@@ -0,0 +1,63 @@
+package chatgpt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrStreamStalled is returned when no data was received on a streamed response for Config.StreamIdleTimeout.
+var ErrStreamStalled = errors.New("stream stalled")
+
+// The default time without data after which a streamed response is considered stalled, see Config.StreamIdleTimeout.
+const DEFAULT_STREAM_IDLE_TIMEOUT = 60 * time.Second
+
+// idleReader closes a response body once a read waited for data for a timeout, the reads then fail with
+// ErrStreamStalled instead of the error of the closed body. The time spent by slow consumers between reads doesn't count.
+type idleReader struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	mu      sync.Mutex
+	stalled bool
+}
+
+// withIdleTimeout wraps a response body to close it when it stalls for Config.StreamIdleTimeout, unless disabled.
+func (c *Client) withIdleTimeout(body io.ReadCloser) io.ReadCloser {
+	if c.idleTimeout <= 0 {
+		return body
+	}
+	r := &idleReader{ReadCloser: body, timeout: c.idleTimeout}
+	r.timer = time.AfterFunc(r.timeout, r.stall)
+	r.timer.Stop() // armed by each read
+	return r
+}
+
+// stall closes the body when the timeout expires.
+func (r *idleReader) stall() {
+	r.mu.Lock()
+	r.stalled = true
+	r.mu.Unlock()
+	r.ReadCloser.Close()
+}
+
+// Read reads from the body, closing it if no data arrives within the timeout.
+func (r *idleReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, err := r.ReadCloser.Read(p)
+	r.timer.Stop()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stalled {
+		return n, fmt.Errorf("%w: no data received for %s", ErrStreamStalled, r.timeout)
+	}
+	return n, err
+}
+
+// Close stops the timeout and closes the body.
+func (r *idleReader) Close() error {
+	r.timer.Stop()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,141 @@
+package chatgpt
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// The value of Config.ReplyLanguage detecting the language of each prompt.
+const ReplyLanguageAuto = "auto"
+
+// languageNames are the names of the languages used in the reply language instruction, by ISO 639-1 code.
+var languageNames = map[string]string{
+	"ar": "Arabic", "de": "German", "el": "Greek", "en": "English", "es": "Spanish", "fr": "French",
+	"he": "Hebrew", "hi": "Hindi", "it": "Italian", "ja": "Japanese", "ko": "Korean", "nl": "Dutch",
+	"pt": "Portuguese", "ru": "Russian", "th": "Thai", "zh": "Chinese",
+}
+
+// languageTrigrams are the most frequent trigrams of the Latin script languages told apart by DetectLanguage.
+var languageTrigrams = map[string][]string{
+	"en": {" th", "the", "he ", "ing", "and", " an", "nd ", " to", "ng ", "to ", "ion", " of", "of ", " in", "ed ", "is ", "er ", " is", "you", " yo", "ou ", "hat", "at ", "tha", "for", " wh", "ent", "re ", " ca", "can"},
+	"es": {" de", "de ", "que", " qu", "ue ", " la", "la ", "os ", "el ", " el", "es ", " en", "en ", " co", "as ", "ent", "ión", "ció", "do ", "ar ", " es", "con", "por", " po", "ra ", "lo ", "est", "cóm", "qué", "una"},
+	"fr": {" de", "es ", "de ", "le ", " le", "ent", " la", "la ", "re ", "les", " et", "et ", "que", " qu", "ue ", "ion", " co", "ne ", "tio", " pa", " un", "ous", "est", " es", "vou", " vo", "pas", "our", "ais", "eux"},
+	"de": {"en ", "er ", "ich", "der", " de", "ein", "sch", "ie ", "die", " di", "ch ", "che", " un", "und", "nd ", "den", "cht", " ei", "ist", " is", " ic", "ine", "gen", "te ", "nic", "ber", "ung", " wi", "wie", "auf"},
+	"it": {" di", "di ", "che", " ch", "la ", "re ", " la", "to ", "del", "ell", "lla", "ent", "are", " co", "one", "zio", "ion", " pe", "per", "er ", " il", "il ", "no ", "ne ", "ato", " un", "sta", "non", " no", "gli"},
+	"pt": {" de", "de ", "os ", " qu", "que", "ue ", "do ", " co", "ão ", "ção", " a ", "da ", " da", "em ", " em", "ent", "as ", "ra ", "não", " nã", "um ", "com", "est", "par", "ara", "uma", " po", "por", "voc", "cê "},
+	"nl": {"en ", "de ", " de", "het", " he", "et ", "een", " ee", "van", " va", "an ", "ijk", "er ", "aar", "ter", "ing", "oor", "nd ", "ie ", "ik ", "jk ", " ik", "dat", " da", "is ", "wat", "oe ", "hoe", " ho", "iet"},
+}
+
+// scriptLanguages are the languages detected by their script, checked in order.
+var scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"}, {unicode.Katakana, "ja"}, {unicode.Hangul, "ko"}, {unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"}, {unicode.Arabic, "ar"}, {unicode.Devanagari, "hi"}, {unicode.Greek, "el"},
+	{unicode.Hebrew, "he"}, {unicode.Thai, "th"},
+}
+
+// DetectLanguage returns the ISO 639-1 code of the language of text, or an empty string if it can't tell.
+// It is a lightweight detector for the reply language: non Latin scripts are recognized by their characters,
+// and English, Spanish, French, German, Italian, Portuguese and Dutch by their most frequent trigrams.
+func DetectLanguage(text string) string {
+	text = strings.ToLower(text)
+
+	// Detect the language from the script of most letters, if it is not Latin
+	var letters, latin int
+	scripts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, script := range scriptLanguages {
+			if unicode.Is(script.script, r) {
+				scripts[script.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	if latin*2 < letters {
+		for _, script := range scriptLanguages {
+			// Japanese is written with kana and kanji, any kana tells it apart from Chinese
+			if scripts[script.language] > 0 {
+				return script.language
+			}
+		}
+		return ""
+	}
+
+	// Score the Latin script languages by the number of their frequent trigrams in the text
+	words := strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) })
+	padded := []rune(" " + strings.Join(words, " ") + " ")
+	counts := make(map[string]int)
+	for i := 0; i+3 <= len(padded); i++ {
+		counts[string(padded[i:i+3])]++
+	}
+	// Trigrams shared by several languages weigh less than the ones telling a language apart
+	shared := make(map[string]int)
+	for _, trigrams := range languageTrigrams {
+		for _, trigram := range trigrams {
+			shared[trigram]++
+		}
+	}
+	best, bestScore, tied := "", 0.0, false
+	for language, trigrams := range languageTrigrams {
+		score := 0.0
+		for _, trigram := range trigrams {
+			score += float64(counts[trigram]) / float64(shared[trigram])
+		}
+		if score > bestScore {
+			best, bestScore, tied = language, score, false
+		} else if score == bestScore {
+			tied = true
+		}
+	}
+	// Too short or ambiguous texts are left undetected rather than guessed
+	if bestScore < 2 || tied {
+		return ""
+	}
+	return best
+}
+
+// replyLanguage returns the language the reply to prompt is pinned to with Config.ReplyLanguage, empty if none.
+func (c *Client) replyLanguage(prompt string) string {
+	switch c.replyLang {
+	case "":
+		return ""
+	case ReplyLanguageAuto:
+		return DetectLanguage(prompt)
+	default:
+		return strings.ToLower(c.replyLang)
+	}
+}
+
+// replyLanguageInstruction returns the one-line instruction pinning the reply to a language.
+func replyLanguageInstruction(language string) string {
+	if name, ok := languageNames[language]; ok {
+		return fmt.Sprintf("Reply in %s.", name)
+	}
+	return fmt.Sprintf("Reply in the language with the ISO 639-1 code %q.", language)
+}
+
+// withReplyLanguage returns the messages sent for prompt, with a system message pinning the reply language appended
+// if Config.ReplyLanguage is set, and the pinned language. The messages of the conversation are not modified.
+func (c *Client) withReplyLanguage(messages []Message, prompt string) ([]Message, string) {
+	language := c.replyLanguage(prompt)
+	if language == "" {
+		return messages, ""
+	}
+	pinned := make([]Message, len(messages), len(messages)+1)
+	copy(pinned, messages)
+	return append(pinned, Message{Role: "system", Content: replyLanguageInstruction(language)}), language
+}
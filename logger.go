@@ -0,0 +1,153 @@
+package chatgpt
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Logger is a simple logger that can be used to log messages to the console.
+type Logger struct {
+	// The minimum level of messages to log.
+	Level LogLevel
+	// TimeFormat is the layout of the time prefixed to the messages, e.g. time.RFC3339.
+	// When set, it replaces the date and time added by the log package.
+	TimeFormat string
+	// LevelPrefix formats the messages as "[INFO] chatgpt: message" instead of "chatgpt - Info - message".
+	LevelPrefix bool
+	// sessionName is the name of the session.
+	sessionName string
+}
+
+// SetLevel sets the minimum level of messages to log.
+func (l *Logger) SetLevel(level LogLevel) {
+	l.Level = level
+}
+
+// LogLevel is an enum for the different log levels.
+type LogLevel int
+
+const (
+	// LogLevelNone disables all logging.
+	LogLevelNone LogLevel = iota
+	// LogLevelDebug logs debug messages.
+	LogLevelDebug
+	// LogLevelInfo logs informational messages.
+	LogLevelInfo
+	// LogLevelWarn logs warning messages.
+	LogLevelWarn
+	// LogLevelError logs error messages.
+	LogLevelError
+)
+
+// levelNames are the names of the log levels in the messages.
+var levelNames = map[LogLevel]string{
+	LogLevelDebug: "Debug",
+	LogLevelInfo:  "Info",
+	LogLevelWarn:  "Warn",
+	LogLevelError: "Error",
+}
+
+// SessionName returns the name of the session, or an empty string if it's the default session.
+func (l *Logger) SessionName() string {
+	if l.sessionName == "default" {
+		return ""
+	}
+	return l.sessionName
+}
+
+// Debug logs a debug message.
+func (l *Logger) Debug(msg string) {
+	l.log(LogLevelDebug, msg)
+}
+
+// Info logs an informational message.
+func (l *Logger) Info(msg string) {
+	l.log(LogLevelInfo, msg)
+}
+
+// Warn logs a warning message.
+func (l *Logger) Warn(msg string) {
+	l.log(LogLevelWarn, msg)
+}
+
+// Error logs an error message.
+func (l *Logger) Error(msg string) {
+	l.log(LogLevelError, msg)
+}
+
+// Debugf logs a debug message formatted with fmt.Sprintf.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(LogLevelDebug, format, args...)
+}
+
+// Infof logs an informational message formatted with fmt.Sprintf.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(LogLevelInfo, format, args...)
+}
+
+// Warnf logs a warning message formatted with fmt.Sprintf.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.logf(LogLevelWarn, format, args...)
+}
+
+// Errorf logs an error message formatted with fmt.Sprintf.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(LogLevelError, format, args...)
+}
+
+// Fatalf logs an error message formatted with fmt.Sprintf whatever the level, and exits with status 1.
+// It is never called by the client, which returns errors instead.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.write(LogLevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// logf formats and logs a message if its level is enabled, the arguments are only formatted then.
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	if l.Level <= level {
+		l.write(level, fmt.Sprintf(format, args...))
+	}
+}
+
+// log logs a message if its level is enabled.
+func (l *Logger) log(level LogLevel, msg string) {
+	if l.Level <= level {
+		l.write(level, msg)
+	}
+}
+
+// write writes a message with the prefix of its level and session, in the configured format.
+func (l *Logger) write(level LogLevel, msg string) {
+	var line string
+	if l.LevelPrefix {
+		line = fmt.Sprintf("[%s] chatgpt%s: %s", levelTag(level), l.SessionName(), msg)
+	} else {
+		line = fmt.Sprintf("chatgpt%s - %s - %s", l.SessionName(), levelNames[level], msg)
+	}
+	if l.TimeFormat == "" {
+		log.Print(line)
+		return
+	}
+	fmt.Fprintln(log.Writer(), time.Now().Format(l.TimeFormat)+" "+line)
+}
+
+// levelTag returns the upper case name of a level, e.g. INFO.
+func levelTag(level LogLevel) string {
+	switch level {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// Logger returns the logger of the client, e.g. to change its level or format.
+func (c *Client) Logger() *Logger {
+	return c.logger
+}
@@ -0,0 +1,35 @@
+package examples
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	gpt := chatgpt.NewClient(&chatgpt.Config{
+		ApiKey: "sk-xxxxxxxx",
+	})
+
+	// Export the client metrics on http://localhost:2112/metrics
+	collector := metrics.NewCollector()
+	prometheus.MustRegister(collector)
+	collector.Register(gpt)
+	http.Handle("/metrics", promhttp.Handler())
+	go http.ListenAndServe(":2112", nil)
+
+	if err := gpt.Start(); err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+	response, err := gpt.Ask(ctx, "Hello")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(response)
+}
@@ -0,0 +1,143 @@
+// Package metrics exports the events of chatgpt clients as Prometheus metrics.
+//
+// It is a separate module so that the chatgpt package doesn't depend on the Prometheus client,
+// built against the chatgpt package of the same checkout through a replace directive.
+// Create a Collector, register it with a Prometheus registry and observe clients with Collector.Register:
+//
+//	collector := metrics.NewCollector()
+//	prometheus.MustRegister(collector)
+//	collector.Register(client)
+package metrics
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The namespace of the metrics of a Collector.
+const DEFAULT_NAMESPACE = "chatgpt"
+
+// Collector is a prometheus.Collector of the requests, token usage, streams and cache lookups of chatgpt clients,
+// updated from the events of the clients it is registered with.
+//
+// The exported metrics are:
+//   - chatgpt_requests_total{mode, code}: requests sent to OpenAI by auth mode and HTTP status code, "error" if none was received.
+//   - chatgpt_request_duration_seconds{mode, engine}: the latency of the requests.
+//   - chatgpt_tokens_total{engine, type}: the prompt and completion tokens used in API key mode.
+//   - chatgpt_active_streams: the streamed responses currently open, read from the clients when collected.
+//   - chatgpt_cache_lookups_total{cache, result}: the hits and misses of the response and access token caches.
+type Collector struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	tokens   *prometheus.CounterVec
+	streams  prometheus.GaugeFunc
+	cache    *prometheus.CounterVec
+
+	mu      sync.Mutex
+	clients []*chatgpt.Client
+}
+
+// NewCollector returns a Collector whose metrics are prefixed with the given namespace, DEFAULT_NAMESPACE by default.
+func NewCollector(namespace ...string) *Collector {
+	ns := DEFAULT_NAMESPACE
+	if len(namespace) > 0 && namespace[0] != "" {
+		ns = namespace[0]
+	}
+	m := &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "requests_total",
+			Help:      "The requests sent to OpenAI by auth mode and HTTP status code.",
+		}, []string{"mode", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "request_duration_seconds",
+			Help:      "The latency of the requests sent to OpenAI, until the response headers for streams.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80},
+		}, []string{"mode", "engine"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "tokens_total",
+			Help:      "The prompt and completion tokens used by engine, in API key mode.",
+		}, []string{"engine", "type"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "cache_lookups_total",
+			Help:      "The lookups of the response and access token caches by result.",
+		}, []string{"cache", "result"}),
+	}
+	// Events can be dropped when the queue of a client is full, so the open streams are counted by the clients
+	m.streams = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: ns,
+		Name:      "active_streams",
+		Help:      "The streamed responses currently open.",
+	}, m.activeStreams)
+	return m
+}
+
+// Register observes the events of a client, it can be called with several clients to aggregate their metrics.
+// It should be called before Start to count the access token cache lookup.
+func (m *Collector) Register(client *chatgpt.Client) {
+	m.mu.Lock()
+	m.clients = append(m.clients, client)
+	m.mu.Unlock()
+	client.OnEvent(m.Observe)
+}
+
+// activeStreams returns the streams currently open by the registered clients.
+func (m *Collector) activeStreams() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	streams := 0
+	for _, client := range m.clients {
+		streams += client.ActiveStreams()
+	}
+	return float64(streams)
+}
+
+// Observe updates the metrics with a client event, it is the handler added by Register.
+// The active streams are only counted for the clients added with Register.
+func (m *Collector) Observe(event chatgpt.Event) {
+	switch event.Type {
+	case chatgpt.EventRequestCompleted:
+		code := "error"
+		if event.StatusCode != 0 {
+			code = strconv.Itoa(event.StatusCode)
+		}
+		m.requests.WithLabelValues(event.Mode, code).Inc()
+		m.latency.WithLabelValues(event.Mode, event.Engine).Observe(event.Duration.Seconds())
+		if event.PromptTokens > 0 {
+			m.tokens.WithLabelValues(event.Engine, "prompt").Add(float64(event.PromptTokens))
+		}
+		if event.CompletionTokens > 0 {
+			m.tokens.WithLabelValues(event.Engine, "completion").Add(float64(event.CompletionTokens))
+		}
+	case chatgpt.EventCacheLookup:
+		result := "miss"
+		if event.Hit {
+			result = "hit"
+		}
+		m.cache.WithLabelValues(event.Cache, result).Inc()
+	}
+}
+
+// Describe sends the descriptors of the metrics to ch, it implements prometheus.Collector.
+func (m *Collector) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.latency.Describe(ch)
+	m.tokens.Describe(ch)
+	m.streams.Describe(ch)
+	m.cache.Describe(ch)
+}
+
+// Collect sends the current values of the metrics to ch, it implements prometheus.Collector.
+func (m *Collector) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.latency.Collect(ch)
+	m.tokens.Collect(ch)
+	m.streams.Collect(ch)
+	m.cache.Collect(ch)
+}
@@ -0,0 +1,123 @@
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+	"github.com/amarnathcjd/chatgpt/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserve(t *testing.T) {
+	collector := metrics.NewCollector()
+	for _, event := range []chatgpt.Event{
+		{Type: chatgpt.EventRequestCompleted, Mode: chatgpt.AuthModeApiKey, Engine: chatgpt.EngineGPT4, StatusCode: 200,
+			Duration: 300 * time.Millisecond, PromptTokens: 10, CompletionTokens: 5},
+		{Type: chatgpt.EventRequestCompleted, Mode: chatgpt.AuthModeApiKey, Engine: chatgpt.EngineGPT4, StatusCode: 200,
+			Duration: 2 * time.Second, PromptTokens: 20, CompletionTokens: 7},
+		{Type: chatgpt.EventRequestCompleted, Mode: chatgpt.AuthModeAccessToken, Engine: chatgpt.EngineGPT4, StatusCode: 429},
+		{Type: chatgpt.EventRequestCompleted, Mode: chatgpt.AuthModeAccessToken, Engine: chatgpt.EngineGPT4},
+		{Type: chatgpt.EventCacheLookup, Cache: chatgpt.CacheResponse, Hit: true},
+		{Type: chatgpt.EventCacheLookup, Cache: chatgpt.CacheResponse},
+		{Type: chatgpt.EventCacheLookup, Cache: chatgpt.CacheToken},
+		{Type: chatgpt.EventConversationCreated, ConversationID: "ignored"},
+	} {
+		collector.Observe(event)
+	}
+
+	want := `
+# HELP chatgpt_requests_total The requests sent to OpenAI by auth mode and HTTP status code.
+# TYPE chatgpt_requests_total counter
+chatgpt_requests_total{code="200",mode="api_key"} 2
+chatgpt_requests_total{code="429",mode="access_token"} 1
+chatgpt_requests_total{code="error",mode="access_token"} 1
+# HELP chatgpt_tokens_total The prompt and completion tokens used by engine, in API key mode.
+# TYPE chatgpt_tokens_total counter
+chatgpt_tokens_total{engine="gpt-4",type="completion"} 12
+chatgpt_tokens_total{engine="gpt-4",type="prompt"} 30
+# HELP chatgpt_cache_lookups_total The lookups of the response and access token caches by result.
+# TYPE chatgpt_cache_lookups_total counter
+chatgpt_cache_lookups_total{cache="response",result="hit"} 1
+chatgpt_cache_lookups_total{cache="response",result="miss"} 1
+chatgpt_cache_lookups_total{cache="token",result="miss"} 1
+# HELP chatgpt_active_streams The streamed responses currently open.
+# TYPE chatgpt_active_streams gauge
+chatgpt_active_streams 0
+`
+	names := []string{"chatgpt_requests_total", "chatgpt_tokens_total", "chatgpt_cache_lookups_total", "chatgpt_active_streams"}
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(collector, "chatgpt_request_duration_seconds"); n != 2 {
+		t.Errorf("got %d latency histograms, want one per mode", n)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	collector := metrics.NewCollector("bot")
+	collector.Observe(chatgpt.Event{Type: chatgpt.EventCacheLookup, Cache: chatgpt.CacheToken, Hit: true})
+	if n := testutil.CollectAndCount(collector, "bot_cache_lookups_total"); n != 1 {
+		t.Errorf("got %d namespaced cache metrics", n)
+	}
+	// The collector can be registered with a registry
+	if err := prometheus.NewRegistry().Register(collector); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Hello there"})
+	server.ChunkDelay = 20 * time.Millisecond
+	defer server.Close()
+	client := chatgpt.NewClient(&chatgpt.Config{AccessToken: "token", BaseURL: server.ConversationURL(),
+		DisableCache: true, LogLevel: chatgpt.LogLevelError})
+	collector := metrics.NewCollector()
+	collector.Register(client)
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The open streams are read from the client
+	ch, err := client.AskStream(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	streams := `
+# HELP chatgpt_active_streams The streamed responses currently open.
+# TYPE chatgpt_active_streams gauge
+chatgpt_active_streams %d
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(fmt.Sprintf(streams, 1)), "chatgpt_active_streams"); err != nil {
+		t.Errorf("while streaming: %v", err)
+	}
+	for range ch {
+	}
+
+	// The events are dispatched asynchronously
+	requests := `
+# HELP chatgpt_requests_total The requests sent to OpenAI by auth mode and HTTP status code.
+# TYPE chatgpt_requests_total counter
+chatgpt_requests_total{code="200",mode="access_token"} 1
+`
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := testutil.CollectAndCompare(collector, strings.NewReader(requests), "chatgpt_requests_total")
+		if err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(fmt.Sprintf(streams, 0)), "chatgpt_active_streams"); err != nil {
+		t.Errorf("once the stream is done: %v", err)
+	}
+}
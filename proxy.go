@@ -0,0 +1,126 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// TransportConfig holds the connection tuning knobs of the transport built by the client, see Config.Transport.
+type TransportConfig struct {
+	MaxIdleConnsPerHost int           `json:"max_idle_conns_per_host,omitempty"` // The maximum number of idle connections kept per host, defaults to 2.
+	IdleConnTimeout     time.Duration `json:"idle_conn_timeout,omitempty"`       // How long idle connections are kept, defaults to 90 seconds.
+	ForceHTTP2          bool          `json:"force_http2,omitempty"`             // Whether or not to attempt HTTP/2 with the tuned transport.
+}
+
+// defaultTransport is the transport used when no proxy is configured, shared by the clients.
+// Like http.DefaultTransport, which it is copied from before any user code could replace it,
+// it sends requests through the proxy set in the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+var defaultTransport = func() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	return transport
+}()
+
+// switchTransport is the base transport of the client, which can be swapped at runtime by SetProxy
+// while requests are in flight. Requests already sent keep using the transport they started with.
+type switchTransport struct {
+	mu sync.RWMutex
+	rt http.RoundTripper
+}
+
+// RoundTrip sends the request with the current transport, asking for a compressed response and decompressing it.
+func (t *switchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	rt := t.rt
+	t.mu.RUnlock()
+	resp, err := rt.RoundTrip(acceptCompression(req))
+	if err != nil {
+		return nil, err
+	}
+	decompressResponse(resp)
+	return resp, nil
+}
+
+// set replaces the current transport, closing the idle connections of the previous one.
+func (t *switchTransport) set(rt http.RoundTripper) {
+	t.mu.Lock()
+	old := t.rt
+	t.rt = rt
+	t.mu.Unlock()
+	if old != nil && old != defaultTransport {
+		closeIdleConnections(old)
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the current transport, it's called by http.Client.CloseIdleConnections.
+func (t *switchTransport) CloseIdleConnections() {
+	t.mu.RLock()
+	rt := t.rt
+	t.mu.RUnlock()
+	closeIdleConnections(rt)
+}
+
+// closeIdleConnections closes the idle connections of rt if it supports it.
+func closeIdleConnections(rt http.RoundTripper) {
+	if closer, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// newTransport returns the transport built by the client, from a copy of defaultTransport.
+// Requests are sent through proxyUrl if it is not nil, otherwise through the proxy of the environment,
+// and the transport is tuned with config if it is not nil.
+//
+//	Supported proxy schemes:
+//	 - http and https: credentials in the URL are sent as Proxy-Authorization, including on CONNECT
+//	 - socks5 and socks5h: credentials in the URL are used for the SOCKS5 username/password authentication
+func newTransport(proxyUrl *url.URL, config *TransportConfig) (*http.Transport, error) {
+	transport := defaultTransport.Clone()
+	if config != nil {
+		if config.MaxIdleConnsPerHost > 0 {
+			transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+		}
+		if config.IdleConnTimeout > 0 {
+			transport.IdleConnTimeout = config.IdleConnTimeout
+		}
+		transport.ForceAttemptHTTP2 = config.ForceHTTP2
+	}
+	if proxyUrl == nil {
+		return transport, nil
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "https":
+		transport.Proxy = http.ProxyURL(proxyUrl)
+		if proxyUrl.User != nil {
+			password, _ := proxyUrl.User.Password()
+			credentials := base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.Username() + ":" + password))
+			transport.ProxyConnectHeader = http.Header{"Proxy-Authorization": {"Basic " + credentials}}
+		}
+		return transport, nil
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(proxyUrl, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOCKS5 proxy: %w", err)
+		}
+		transport.Proxy = nil
+		if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+			transport.DialContext = contextDialer.DialContext
+		} else {
+			transport.DialContext = func(_ context.Context, network, addr string) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			}
+		}
+		return transport, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyUrl.Scheme)
+	}
+}
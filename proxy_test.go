@@ -0,0 +1,196 @@
+package chatgpt_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+// proxiedHost is the API host of the requests sent through the proxies, which is never resolved.
+const proxiedHost = "http://api.example.invalid"
+
+// startProxiedClient starts a client in API key mode sending its requests to proxiedHost through config.Proxy.
+func startProxiedClient(t *testing.T, config chatgpt.Config) *chatgpt.Client {
+	t.Helper()
+	host := chatgpt.OPENAI_HOST
+	chatgpt.OPENAI_HOST = proxiedHost + "/v1/chat/completions"
+	t.Cleanup(func() { chatgpt.OPENAI_HOST = host })
+	config.ApiKey = "sk-test"
+	config.ProxyCheckURL = proxiedHost
+	return startClient(t, config)
+}
+
+func TestHTTPProxy(t *testing.T) {
+	// The fake server answers the requests it receives as a proxy, their path being the one of the API
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Proxied"})
+	defer server.Close()
+	proxyUrl, _ := url.Parse(server.URL)
+	proxyUrl.User = url.UserPassword("user", "secret")
+	client := startProxiedClient(t, chatgpt.Config{Proxy: proxyUrl})
+
+	response, err := client.Ask(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Message != "Proxied" {
+		t.Errorf("got %q", response.Message)
+	}
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	if got := server.Requests()[0].Header.Get("Proxy-Authorization"); got != want {
+		t.Errorf("got Proxy-Authorization %q, want %q", got, want)
+	}
+}
+
+// socksProxy is a minimal SOCKS5 server, which records the credentials of its connections.
+type socksProxy struct {
+	net.Listener
+	mu          sync.Mutex
+	credentials []string
+}
+
+func startSocksProxy(t *testing.T) *socksProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &socksProxy{Listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return p
+}
+
+// serve handles the greeting, the username/password authentication and the CONNECT command of a connection.
+func (p *socksProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil
+		}
+		return buf
+	}
+	header := read(2)
+	if header == nil || read(int(header[1])) == nil {
+		return
+	}
+	conn.Write([]byte{5, 2}) // username/password
+	auth := read(2)
+	if auth == nil {
+		return
+	}
+	user := read(int(auth[1]))
+	length := read(1)
+	if user == nil || length == nil {
+		return
+	}
+	password := read(int(length[0]))
+	p.mu.Lock()
+	p.credentials = append(p.credentials, string(user)+":"+string(password))
+	p.mu.Unlock()
+	conn.Write([]byte{1, 0})
+
+	request := read(4)
+	if request == nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	case 4:
+		host = net.IP(read(16)).String()
+	}
+	port := binary.BigEndian.Uint16(read(2))
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		server := chatgpttest.NewServer(chatgpttest.Response{Message: "Through SOCKS"})
+		socks := startSocksProxy(t)
+		proxyUrl := &url.URL{Scheme: scheme, Host: socks.Addr().String(), User: url.UserPassword("user", "secret")}
+		client := startApiKeyClient(t, server, chatgpt.Config{Proxy: proxyUrl, ProxyCheckURL: server.URL})
+
+		response, err := client.Ask(context.Background(), "Hi")
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if response.Message != "Through SOCKS" {
+			t.Errorf("%s: got %q", scheme, response.Message)
+		}
+		socks.mu.Lock()
+		if len(socks.credentials) == 0 || socks.credentials[0] != "user:secret" {
+			t.Errorf("%s: got credentials %q", scheme, socks.credentials)
+		}
+		socks.mu.Unlock()
+		server.Close()
+	}
+}
+
+func TestUnsupportedProxyScheme(t *testing.T) {
+	client := chatgpt.NewClient(&chatgpt.Config{
+		ApiKey:   "sk-test",
+		Proxy:    &url.URL{Scheme: "ftp", Host: "127.0.0.1:21"},
+		LogLevel: chatgpt.LogLevelError,
+	})
+	defer client.Close()
+	if err := client.Start(); err == nil {
+		t.Error("got no error for an ftp proxy")
+	}
+}
+
+// TestEnvironmentProxy runs itself in a new process with HTTP_PROXY set, since the environment is only read once.
+func TestEnvironmentProxy(t *testing.T) {
+	if os.Getenv("CHATGPT_TEST_ENV_PROXY") != "" {
+		client := startProxiedClient(t, chatgpt.Config{})
+		response, err := client.Ask(context.Background(), "Hi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Message != "From the environment" {
+			t.Errorf("got %q", response.Message)
+		}
+		return
+	}
+
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "From the environment"})
+	defer server.Close()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestEnvironmentProxy$")
+	cmd.Env = append(os.Environ(), "CHATGPT_TEST_ENV_PROXY=1", "HTTP_PROXY="+server.URL, "http_proxy="+server.URL, "NO_PROXY=", "no_proxy=")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+	if requests := server.Requests(); len(requests) != 1 || requests[0].Path != "/v1/chat/completions" {
+		t.Errorf("got requests %+v through the environment proxy", requests)
+	}
+}
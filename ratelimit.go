@@ -0,0 +1,116 @@
+package chatgpt
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The default fraction of the rate limits below which a warning is logged, see Config.RateLimitWarnThreshold.
+const DEFAULT_RATE_LIMIT_WARN_THRESHOLD = 0.1
+
+// RateLimits is a snapshot of the rate limit headers returned by the OpenAI API on the last response (ApiKeyMode only).
+// Fields are zero when the header was not returned, e.g. before the first request.
+type RateLimits struct {
+	LimitRequests     int           `json:"limit_requests,omitempty"`     // The maximum number of requests allowed before the limit resets.
+	LimitTokens       int           `json:"limit_tokens,omitempty"`       // The maximum number of tokens allowed before the limit resets.
+	RemainingRequests int           `json:"remaining_requests,omitempty"` // The number of requests left before the limit is reached.
+	RemainingTokens   int           `json:"remaining_tokens,omitempty"`   // The number of tokens left before the limit is reached.
+	ResetRequests     time.Duration `json:"reset_requests,omitempty"`     // How long until the requests limit resets, from UpdatedAt.
+	ResetTokens       time.Duration `json:"reset_tokens,omitempty"`       // How long until the tokens limit resets, from UpdatedAt.
+	UpdatedAt         time.Time     `json:"updated_at,omitempty"`         // When the headers were received.
+}
+
+// RateLimitInfo is an alias of RateLimits, returned by RateLimitStatus.
+type RateLimitInfo = RateLimits
+
+// RateLimits returns a snapshot of the rate limits reported by the last API key mode response.
+func (c *Client) RateLimits() RateLimits {
+	c.rateMu.Lock()
+	defer c.rateMu.Unlock()
+	return c.rateLimits
+}
+
+// RateLimitStatus returns the latest rate limits reported by the OpenAI API, it is the same as RateLimits.
+func (c *Client) RateLimitStatus() RateLimitInfo {
+	return c.RateLimits()
+}
+
+// parseRateLimits reads the x-ratelimit-* headers of an OpenAI API response, ok is false if there are none.
+func parseRateLimits(header http.Header) (limits RateLimits, ok bool) {
+	ints := map[string]*int{
+		"x-ratelimit-limit-requests":     &limits.LimitRequests,
+		"x-ratelimit-limit-tokens":       &limits.LimitTokens,
+		"x-ratelimit-remaining-requests": &limits.RemainingRequests,
+		"x-ratelimit-remaining-tokens":   &limits.RemainingTokens,
+	}
+	for name, field := range ints {
+		if value, err := strconv.Atoi(header.Get(name)); err == nil {
+			*field, ok = value, true
+		}
+	}
+	durations := map[string]*time.Duration{
+		"x-ratelimit-reset-requests": &limits.ResetRequests,
+		"x-ratelimit-reset-tokens":   &limits.ResetTokens,
+	}
+	for name, field := range durations {
+		if value, err := time.ParseDuration(header.Get(name)); err == nil {
+			*field, ok = value, true
+		}
+	}
+	limits.UpdatedAt = time.Now()
+	return limits, ok
+}
+
+// updateRateLimits records the rate limits of an API key mode response,
+// and warns when the remaining requests or tokens drop below the configured threshold.
+func (c *Client) updateRateLimits(header http.Header) {
+	limits, ok := parseRateLimits(header)
+	if !ok {
+		return
+	}
+	c.rateMu.Lock()
+	c.rateLimits = limits
+	c.rateMu.Unlock()
+
+	threshold := c.rateWarnAt
+	if threshold <= 0 {
+		threshold = DEFAULT_RATE_LIMIT_WARN_THRESHOLD
+	}
+	if limits.LimitRequests > 0 && float64(limits.RemainingRequests) < threshold*float64(limits.LimitRequests) {
+		c.logger.Warnf("Rate limit almost reached: %d of %d requests left, resets in %s", limits.RemainingRequests, limits.LimitRequests, limits.ResetRequests)
+	}
+	if limits.LimitTokens > 0 && float64(limits.RemainingTokens) < threshold*float64(limits.LimitTokens) {
+		c.logger.Warnf("Rate limit almost reached: %d of %d tokens left, resets in %s", limits.RemainingTokens, limits.LimitTokens, limits.ResetTokens)
+	}
+}
+
+// waitRateLimits blocks until the exhausted rate limits of the last response reset, if Config.RateLimitPacing is set.
+func (c *Client) waitRateLimits(ctx context.Context) error {
+	if !c.ratePacing {
+		return nil
+	}
+	limits := c.RateLimits()
+	var wait time.Duration
+	if limits.LimitRequests > 0 && limits.RemainingRequests <= 0 {
+		wait = limits.ResetRequests
+	}
+	if limits.LimitTokens > 0 && limits.RemainingTokens <= 0 && limits.ResetTokens > wait {
+		wait = limits.ResetTokens
+	}
+	wait -= time.Since(limits.UpdatedAt)
+	if wait <= 0 {
+		return nil
+	}
+
+	c.logger.Infof("Rate limit reached, waiting %s before sending the request", wait.Round(time.Millisecond))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
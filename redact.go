@@ -0,0 +1,40 @@
+package chatgpt
+
+import "regexp"
+
+// The patterns replaced by RedactPII, in order, with their replacement.
+var piiPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{regexp.MustCompile(`\b\d(?:[ \-]?\d){12,15}\b`), "[CARD]"},
+	{regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), "[SSN]"},
+	{regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?)?(?:\(\d{2,4}\)|\b\d{2,4})[ .\-]\d{3,4}[ .\-]?\d{3,4}\b`), "[PHONE]"},
+}
+
+// RedactPII replaces common personal data in text with placeholders: email addresses, card numbers,
+// US social security numbers and phone numbers. It is a best effort for use as Config.RedactRequest
+// and Config.RedactResponse, wrap it to add patterns specific to your data.
+func RedactPII(text string) string {
+	for _, pii := range piiPatterns {
+		text = pii.pattern.ReplaceAllString(text, pii.replacement)
+	}
+	return text
+}
+
+// redactRequest applies Config.RedactRequest to text, if set.
+func (c *Client) redactRequest(text string) string {
+	if c.redactReq == nil {
+		return text
+	}
+	return c.redactReq(text)
+}
+
+// redactResponse applies Config.RedactResponse to text, if set.
+func (c *Client) redactResponse(text string) string {
+	if c.redactResp == nil {
+		return text
+	}
+	return c.redactResp(text)
+}
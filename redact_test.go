@@ -0,0 +1,115 @@
+package chatgpt_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+const (
+	piiPrompt   = "Mail bob@example.com or call 555-123-4567 about card 4111 1111 1111 1111"
+	piiResponse = "I wrote to alice@example.org, her SSN is 123-45-6789"
+)
+
+// rawPII returns the personal data of piiPrompt and piiResponse found in text.
+func rawPII(text string) []string {
+	var found []string
+	for _, pii := range []string{"bob@example.com", "555-123-4567", "4111 1111 1111 1111", "alice@example.org", "123-45-6789"} {
+		if strings.Contains(text, pii) {
+			found = append(found, pii)
+		}
+	}
+	return found
+}
+
+func TestRedactPII(t *testing.T) {
+	want := "Mail [EMAIL] or call [PHONE] about card [CARD]"
+	if got := chatgpt.RedactPII(piiPrompt); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := chatgpt.RedactPII(piiResponse); got != "I wrote to [EMAIL], her SSN is [SSN]" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestAskRedactsPII(t *testing.T) {
+	buf := captureLog(t)
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: piiResponse})
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{
+		LogLevel:       chatgpt.LogLevelDebug,
+		RedactRequest:  chatgpt.RedactPII,
+		RedactResponse: chatgpt.RedactPII,
+	})
+
+	response, err := client.Ask(context.Background(), piiPrompt, chatgpt.AskOpts{ConversationID: "pii"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := rawPII(response.Message); found != nil {
+		t.Errorf("the response has %q", found)
+	}
+
+	// The payload sent
+	for _, message := range server.Requests()[0].Messages() {
+		if found := rawPII(message[1]); found != nil {
+			t.Errorf("the %s message sent has %q", message[0], found)
+		}
+	}
+
+	// The stored history
+	conversation, err := client.GetConversation("pii")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range conversation.Messages {
+		if found := rawPII(message.Content); found != nil {
+			t.Errorf("the stored %s message has %q", message.Role, found)
+		}
+	}
+	if conversation.Messages[1].Content != chatgpt.RedactPII(piiPrompt) {
+		t.Errorf("got stored prompt %q", conversation.Messages[1].Content)
+	}
+
+	// The debug logs of the request and the response
+	if !strings.Contains(buf.String(), "payload:") || !strings.Contains(buf.String(), "body:") {
+		t.Fatalf("the request and the response aren't logged:\n%s", buf.String())
+	}
+	if found := rawPII(buf.String()); found != nil {
+		t.Errorf("the logs have %q:\n%s", found, buf.String())
+	}
+}
+
+func TestAskStreamRedactsPII(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: piiResponse})
+	defer server.Close()
+	client := startAccessTokenClient(t, server, chatgpt.Config{RedactRequest: chatgpt.RedactPII, RedactResponse: chatgpt.RedactPII})
+
+	ch, err := client.AskStream(context.Background(), piiPrompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last *chatgpt.ChatResponse
+	for message := range ch {
+		if found := rawPII(message.Message); found != nil {
+			t.Errorf("a streamed message has %q", found)
+		}
+		last = message
+	}
+	if last == nil || last.Err != nil || last.Message != chatgpt.RedactPII(piiResponse) {
+		t.Errorf("got last message %+v", last)
+	}
+
+	// The access token payload carries the prompt in content parts
+	payload, _ := json.Marshal(server.Requests()[0].Body)
+	if !strings.Contains(string(payload), "[EMAIL]") {
+		t.Errorf("the prompt isn't sent redacted: %s", payload)
+	}
+	if found := rawPII(string(payload)); found != nil {
+		t.Errorf("the payload sent has %q", found)
+	}
+}
@@ -0,0 +1,293 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ErrRefused is returned by AskJSON when the model refuses to answer instead of returning the structured output.
+var ErrRefused = errors.New("the model refused to answer")
+
+// The name of the schema sent in the response format when AskOpts.SchemaName is not set.
+const DEFAULT_SCHEMA_NAME = "response"
+
+// ResponseFormat represents the response_format of the payload sent by askOpenAI.
+type ResponseFormat struct {
+	Type       string            `json:"type"`                  // "json_schema" for structured outputs.
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"` // The schema the response must conform to.
+}
+
+// JSONSchemaFormat represents the schema of a "json_schema" response format.
+type JSONSchemaFormat struct {
+	Name   string          `json:"name"`   // The name of the schema.
+	Schema json.RawMessage `json:"schema"` // The JSON Schema of the response.
+	Strict bool            `json:"strict"` // Whether or not the response strictly follows the schema.
+}
+
+// responseFormat returns the response format of a request, nil if it has no response schema.
+func responseFormat(askOpts ...AskOpts) *ResponseFormat {
+	if len(askOpts) == 0 || len(askOpts[0].ResponseSchema) == 0 {
+		return nil
+	}
+	name := askOpts[0].SchemaName
+	if name == "" {
+		name = DEFAULT_SCHEMA_NAME
+	}
+	return &ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &JSONSchemaFormat{Name: name, Schema: askOpts[0].ResponseSchema, Strict: true},
+	}
+}
+
+// supportsStructuredOutputs returns true if the engine accepts a "json_schema" response format.
+func supportsStructuredOutputs(engine string) bool {
+	if capabilities, ok := capabilitiesForEngine(engine); ok {
+		return capabilities.structuredOutputs
+	}
+	return strings.HasPrefix(engine, "gpt-4o") || strings.HasPrefix(engine, "gpt-4.1")
+}
+
+// checkStructuredOutputs returns an error if a response schema is requested with an engine that doesn't support it.
+// Unknown engines are allowed with Config.AllowUnknownEngine, for compatible backends.
+func (c *Client) checkStructuredOutputs(engine string, askOpts ...AskOpts) error {
+	if responseFormat(askOpts...) == nil || c.anyEngine || supportsStructuredOutputs(engine) {
+		return nil
+	}
+	return fmt.Errorf("%s doesn't support structured outputs, use a gpt-4o or o1 family engine", engine)
+}
+
+// SchemaFor returns the strict JSON Schema of T, for use as AskOpts.ResponseSchema.
+// T must be a struct, whose exported fields are named after their json tag. All fields are required,
+// and a `description` tag is used as the description of a field. Like with encoding/json, the fields of embedded structs
+// are flattened into the struct, time.Time values are date-time strings, and []byte values are base64 strings.
+// Maps and interfaces are not supported since strict schemas can't have additional properties, nor are recursive types.
+func SchemaFor[T any]() (json.RawMessage, error) {
+	var value T
+	return schemaOf(reflect.TypeOf(&value).Elem())
+}
+
+// schemaOf returns the strict JSON Schema of a struct type.
+func schemaOf(t reflect.Type) (json.RawMessage, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil, fmt.Errorf("the response schema must be an object, got %s", t)
+	}
+	schema, err := schemaForType(t, make(map[reflect.Type]bool))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(schema)
+}
+
+// The types encoded by encoding/json as strings rather than by their kind.
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schemaForType returns the JSON Schema of a type as a map.
+// visiting holds the struct types being described, to report recursive types instead of recursing forever.
+func schemaForType(t reflect.Type, visiting map[reflect.Type]bool) (map[string]interface{}, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "description": "Base64 encoded bytes."}, nil
+	case isTextMarshaler(t):
+		return map[string]interface{}{"type": "string"}, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := schemaForType(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			return nil, fmt.Errorf("recursive type %s is not supported in a strict schema", t)
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		fields, err := schemaFields(t, visiting)
+		if err != nil {
+			return nil, err
+		}
+		properties := make(map[string]interface{}, len(fields))
+		required := make([]string, 0, len(fields))
+		for _, field := range fields {
+			properties[field.name] = field.schema
+			required = append(required, field.name)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s in a strict schema", t)
+	}
+}
+
+// isTextMarshaler returns true if encoding/json encodes the values of t as strings with their MarshalText method.
+func isTextMarshaler(t reflect.Type) bool {
+	pointer := reflect.PointerTo(t)
+	if t.Implements(jsonMarshalerType) || pointer.Implements(jsonMarshalerType) {
+		return false
+	}
+	return t.Implements(textMarshalerType) || pointer.Implements(textMarshalerType)
+}
+
+// schemaField is a property of the schema of a struct.
+type schemaField struct {
+	name   string
+	schema map[string]interface{}
+}
+
+// schemaFields returns the properties of the encoded fields of a struct type, in order. The fields of embedded structs
+// are flattened like encoding/json does, the fields declared in the struct taking precedence over the promoted ones.
+func schemaFields(t reflect.Type, visiting map[reflect.Type]bool) ([]schemaField, error) {
+	var fields, promoted []schemaField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if embedded := embeddedStruct(field); embedded != nil {
+			if visiting[embedded] {
+				return nil, fmt.Errorf("recursive type %s is not supported in a strict schema", embedded)
+			}
+			visiting[embedded] = true
+			embeddedFields, err := schemaFields(embedded, visiting)
+			delete(visiting, embedded)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.Name, err)
+			}
+			promoted = append(promoted, embeddedFields...)
+			continue
+		}
+		name, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+		property, err := schemaForType(field.Type, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			property["description"] = description
+		}
+		fields = append(fields, schemaField{name: name, schema: property})
+	}
+
+	declared := make(map[string]bool, len(fields)+len(promoted))
+	for _, field := range fields {
+		declared[field.name] = true
+	}
+	for _, field := range promoted {
+		if !declared[field.name] {
+			declared[field.name] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// embeddedStruct returns the struct type of an embedded field whose fields encoding/json flattens, nil for other fields.
+// An embedded field with a json name is encoded as a regular field.
+func embeddedStruct(field reflect.StructField) reflect.Type {
+	if !field.Anonymous {
+		return nil
+	}
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+		return nil
+	}
+	t := indirect(field.Type)
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil
+	}
+	return t
+}
+
+// indirect returns the type pointed to by a pointer type, t itself otherwise.
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// jsonFieldName returns the JSON name of a struct field, ok is false if the field is not encoded.
+// Like with encoding/json, the embedded fields of unexported struct types are encoded.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() && !(field.Anonymous && indirect(field.Type).Kind() == reflect.Struct) {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+// AskJSON sends a question like Ask, with a response schema derived from the type of v unless AskOpts.ResponseSchema is set,
+// and decodes the structured response into v, which must be a pointer to a struct.
+// It returns an error wrapping ErrRefused if the model refuses to answer. It is only supported in API key mode.
+func (c *Client) AskJSON(ctx context.Context, prompt string, v interface{}, askOpts ...AskOpts) (*ChatResponse, error) {
+	if c.authmode != ApiKeyMode {
+		return nil, fmt.Errorf("structured outputs: %w", ErrRequiresAPIKey)
+	}
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return nil, fmt.Errorf("AskJSON needs a non-nil pointer, got %T", v)
+	}
+
+	var opts AskOpts
+	if len(askOpts) > 0 {
+		opts = askOpts[0]
+	}
+	if len(opts.ResponseSchema) == 0 {
+		schema, err := schemaOf(target.Type())
+		if err != nil {
+			return nil, err
+		}
+		opts.ResponseSchema = schema
+	}
+	if opts.SchemaName == "" {
+		opts.SchemaName = target.Type().Elem().Name()
+	}
+
+	response, err := c.Ask(ctx, prompt, opts)
+	if err != nil {
+		return nil, err
+	}
+	if response.Refusal != "" {
+		return response, fmt.Errorf("%w: %s", ErrRefused, response.Refusal)
+	}
+	if err := json.Unmarshal([]byte(response.Message), v); err != nil {
+		return response, fmt.Errorf("failed to decode the structured response: %w", err)
+	}
+	return response, nil
+}
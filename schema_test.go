@@ -0,0 +1,135 @@
+package chatgpt_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+)
+
+type schemaBase struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type schemaTagged struct {
+	Value int `json:"value"`
+}
+
+type schemaReport struct {
+	schemaBase
+	*schemaTagged `json:"tagged"`
+	Name          string    `json:"name" description:"The shadowing name"`
+	Created       time.Time `json:"created"`
+	Data          []byte    `json:"data"`
+	Scores        []float64 `json:"scores"`
+	Ignored       string    `json:"-"`
+	private       string
+}
+
+type schemaNode struct {
+	Name string       `json:"name"`
+	Kids []schemaNode `json:"kids"`
+}
+
+type schemaTree struct {
+	Root *schemaNode `json:"root"`
+}
+
+type schemaPair struct {
+	Left  schemaTagged `json:"left"`
+	Right schemaTagged `json:"right"`
+}
+
+func decodeSchema(t *testing.T, raw json.RawMessage) map[string]interface{} {
+	t.Helper()
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestSchemaFor(t *testing.T) {
+	raw, err := chatgpt.SchemaFor[schemaReport]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := decodeSchema(t, raw)
+	if schema["type"] != "object" || schema["additionalProperties"] != false {
+		t.Errorf("got schema %s", raw)
+	}
+	required, _ := schema["required"].([]interface{})
+	want := []interface{}{"tagged", "name", "created", "data", "scores", "id"}
+	if !reflect.DeepEqual(required, want) {
+		t.Errorf("got required %v, want %v", required, want)
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"id":      map[string]interface{}{"type": "string"},
+		"name":    map[string]interface{}{"type": "string", "description": "The shadowing name"},
+		"created": map[string]interface{}{"type": "string", "format": "date-time"},
+		"data":    map[string]interface{}{"type": "string", "description": "Base64 encoded bytes."},
+		"scores":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "number"}},
+		"tagged": map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{"value": map[string]interface{}{"type": "integer"}},
+			"required":             []interface{}{"value"},
+			"additionalProperties": false,
+		},
+	}
+	if !reflect.DeepEqual(properties, expected) {
+		t.Errorf("got properties %v, want %v", properties, expected)
+	}
+
+	// The schema describes what encoding/json produces
+	data, err := json.Marshal(schemaReport{schemaTagged: &schemaTagged{}, Data: []byte("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded map[string]interface{}
+	json.Unmarshal(data, &encoded)
+	for name := range encoded {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("encoded field %q is missing from the schema", name)
+		}
+	}
+	if len(encoded) != len(properties) {
+		t.Errorf("encoded %s, the schema has %d properties", data, len(properties))
+	}
+}
+
+func TestSchemaForRepeatedType(t *testing.T) {
+	raw, err := chatgpt.SchemaFor[schemaPair]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := decodeSchema(t, raw)["properties"].(map[string]interface{})
+	if !reflect.DeepEqual(properties["left"], properties["right"]) {
+		t.Errorf("got different schemas for the same type: %s", raw)
+	}
+}
+
+func TestSchemaForErrors(t *testing.T) {
+	if _, err := chatgpt.SchemaFor[schemaNode](); err == nil || !strings.Contains(err.Error(), "recursive type") {
+		t.Errorf("got error %v for a recursive type", err)
+	}
+	if _, err := chatgpt.SchemaFor[schemaTree](); err == nil || !strings.Contains(err.Error(), "recursive type") {
+		t.Errorf("got error %v for a nested recursive type", err)
+	}
+	if _, err := chatgpt.SchemaFor[struct {
+		Extra map[string]string `json:"extra"`
+	}](); err == nil {
+		t.Error("got no error for a map field")
+	}
+	if _, err := chatgpt.SchemaFor[time.Time](); err == nil {
+		t.Error("got no error for a time.Time response")
+	}
+	if _, err := chatgpt.SchemaFor[[]string](); err == nil {
+		t.Error("got no error for an array response")
+	}
+}
@@ -0,0 +1,65 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// StreamToHTTP streams the response to prompt as Server-Sent Events, for proxying ChatGPT to a browser.
+// Each ChatResponse from AskStream is written as a JSON "data:" event, a stream error as an "error" event,
+// and the end of the stream as a "data: [DONE]" event like the OpenAI API.
+// Pass the request context as ctx so that the stream is cancelled when the client disconnects.
+func (c *Client) StreamToHTTP(ctx context.Context, w http.ResponseWriter, prompt string, opts ...AskOpts) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported by the response writer")
+	}
+
+	ch, err := c.AskStream(ctx, prompt, opts...)
+	if err != nil {
+		return err
+	}
+	// Drain the channel on early return, so that the stream goroutine doesn't block forever
+	defer func() {
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering in nginx
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case message, ok := <-ch:
+			if !ok {
+				fmt.Fprint(w, "data: [DONE]\n\n")
+				flusher.Flush()
+				return nil
+			}
+			if message.Err != nil {
+				data, _ := json.Marshal(map[string]string{"error": message.Err.Error()})
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+				flusher.Flush()
+				return message.Err
+			}
+			data, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+}
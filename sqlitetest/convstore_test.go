@@ -0,0 +1,223 @@
+package sqlitetest_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+	_ "modernc.org/sqlite"
+)
+
+// openDB returns a new SQLite database file, waiting for the locks of concurrent writers instead of failing.
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "conversations.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// startClient starts a client in API key mode asking server, OPENAI_HOST is restored once the test is done.
+func startClient(t *testing.T, server *chatgpttest.Server, store chatgpt.ConversationStore) *chatgpt.Client {
+	t.Helper()
+	host := chatgpt.OPENAI_HOST
+	chatgpt.OPENAI_HOST = server.APIURL()
+	t.Cleanup(func() { chatgpt.OPENAI_HOST = host })
+
+	client := chatgpt.NewClient(&chatgpt.Config{ApiKey: "sk-test", DisableCache: true, LogLevel: chatgpt.LogLevelError, ConversationStore: store})
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// conversation returns a conversation of a system message and the given number of turns.
+func conversation(turns int) chatgpt.Conversation {
+	messages := []chatgpt.Message{{Role: "system", Content: "You are a test."}}
+	for i := 0; i < turns; i++ {
+		messages = append(messages, chatgpt.Message{Role: "user", Content: fmt.Sprint("question ", i)},
+			chatgpt.Message{Role: "assistant", Content: fmt.Sprint("answer ", i)})
+	}
+	var conv chatgpt.Conversation
+	conv.FromMessages(messages)
+	return conv
+}
+
+func TestSQLConversationStore(t *testing.T) {
+	db := openDB(t)
+	if _, err := chatgpt.NewSQLConversationStore(db, "drop table;"); err == nil {
+		t.Error("invalid table name accepted")
+	}
+	store, err := chatgpt.NewSQLConversationStore(db, "store_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Creating the store again keeps the existing table
+	if _, err := chatgpt.NewSQLConversationStore(db, "store_test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Load("missing"); !errors.Is(err, chatgpt.ErrConversationNotFound) {
+		t.Errorf("got error %v loading a missing conversation", err)
+	}
+	first := conversation(1)
+	first.Engine = chatgpt.EngineGPT4
+	if err := store.Save("first", first); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := store.Save("second", conversation(2)); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.Load("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Engine != chatgpt.EngineGPT4 || len(loaded.Messages) != len(first.Messages) || loaded.LastMessage != first.LastMessage {
+		t.Errorf("got %+v, want %+v", loaded, first)
+	}
+	if ids, err := store.List(); err != nil || strings.Join(ids, ",") != "second,first" {
+		t.Errorf("got IDs %q (%v), want the most recently updated first", ids, err)
+	}
+
+	// Saving again replaces the row and moves it first
+	time.Sleep(time.Millisecond)
+	if err := store.Save("first", conversation(3)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := store.Load("first"); len(loaded.Messages) != 7 || loaded.Engine != "" {
+		t.Errorf("got %+v after replacing the conversation", loaded)
+	}
+	if ids, _ := store.List(); strings.Join(ids, ",") != "first,second" {
+		t.Errorf("got IDs %q after replacing the conversation", ids)
+	}
+
+	if err := store.Delete("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("first"); err != nil {
+		t.Errorf("deleting a missing conversation: %v", err)
+	}
+	if ids, _ := store.List(); strings.Join(ids, ",") != "second" {
+		t.Errorf("got IDs %q after deleting", ids)
+	}
+}
+
+func TestClientConversationStore(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Hello"}, chatgpttest.Response{Message: "Again"})
+	defer server.Close()
+	store, err := chatgpt.NewSQLConversationStore(openDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Turns are written through to the store
+	client := startClient(t, server, store)
+	if _, err := client.Ask(context.Background(), "Hi", chatgpt.AskOpts{ConversationID: "stored"}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := store.Load("stored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.LastMessage != "Hello" || len(saved.Messages) != 3 {
+		t.Errorf("got saved conversation %+v", saved)
+	}
+
+	// Another client, e.g. after a restart, reads the conversation from the store to continue it
+	restarted := startClient(t, server, store)
+	if restarted.ConversationCount() != 0 || !restarted.HasConversation("stored") {
+		t.Fatal("the stored conversation is not read through")
+	}
+	if _, err := restarted.Ask(context.Background(), "Hi again", chatgpt.AskOpts{ConversationID: "stored"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(server.Requests()[1].Messages()); got != 4 {
+		t.Errorf("got %d messages sent, want the 3 stored ones and the prompt", got)
+	}
+	if saved, _ := store.Load("stored"); saved.LastMessage != "Again" {
+		t.Errorf("got last saved message %q", saved.LastMessage)
+	}
+
+	restarted.ResetConversation("stored")
+	if _, err := store.Load("stored"); !errors.Is(err, chatgpt.ErrConversationNotFound) {
+		t.Errorf("got error %v loading a reset conversation", err)
+	}
+}
+
+func TestConcurrentConversationStore(t *testing.T) {
+	const workers = 8
+	server := chatgpttest.NewServer()
+	defer server.Close()
+	for i := 0; i < 3*workers; i++ {
+		server.AddResponses(chatgpttest.Response{Message: "Hello"})
+	}
+	store, err := chatgpt.NewSQLConversationStore(openDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := startClient(t, server, store)
+	for i := 0; i < workers; i++ {
+		if err := store.Save(fmt.Sprint("reset-", i), conversation(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each worker asks, sets and resets its own conversations, and all of them a shared one
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(4)
+		go func(i int) {
+			defer wg.Done()
+			for turn := 0; turn < 2; turn++ {
+				if _, err := client.Ask(context.Background(), "Hi", chatgpt.AskOpts{ConversationID: fmt.Sprint("ask-", i)}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			client.SetConversation(fmt.Sprint("set-", i), conversation(i))
+			client.SetConversation("shared", conversation(i))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			client.ResetConversation(fmt.Sprint("reset-", i))
+			client.ResetConversation("shared")
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Ask(context.Background(), "Hi", chatgpt.AskOpts{ConversationID: "shared"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < workers; i++ {
+		if saved, err := store.Load(fmt.Sprint("ask-", i)); err != nil || len(saved.Messages) != 5 {
+			t.Errorf("got saved conversation ask-%d %+v (%v), want 2 turns", i, saved, err)
+		}
+		if saved, err := store.Load(fmt.Sprint("set-", i)); err != nil || len(saved.Messages) != 1+2*i {
+			t.Errorf("got saved conversation set-%d %+v (%v)", i, saved, err)
+		}
+		if _, err := store.Load(fmt.Sprint("reset-", i)); !errors.Is(err, chatgpt.ErrConversationNotFound) {
+			t.Errorf("got error %v loading the reset conversation reset-%d", err, i)
+		}
+	}
+	if ids, err := store.List(); err != nil || len(ids) < 2*workers {
+		t.Errorf("got IDs %q (%v)", ids, err)
+	}
+}
@@ -0,0 +1,5 @@
+// Package sqlitetest tests chatgpt.SQLConversationStore against a real SQLite database.
+//
+// It is a separate module so that the chatgpt module doesn't depend on a SQLite driver, it has no API.
+// Run the tests from this directory with go test -race ./...
+package sqlitetest
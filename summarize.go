@@ -0,0 +1,80 @@
+package chatgpt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The values of Config.CompressionStrategy.
+const (
+	CompressionTruncate  = "truncate"  // Drop all messages but the system message and the latest prompt once they don't fit in the token limit, see Config.ResponseReserve.
+	CompressionSummarize = "summarize" // Replace the oldest messages with a summary once the compression threshold is reached.
+)
+
+// The defaults of Config.CompressionThreshold and Config.CompressionMaxRuns.
+const (
+	DEFAULT_COMPRESSION_THRESHOLD = 0.75
+	DEFAULT_COMPRESSION_MAX_RUNS  = 3
+)
+
+// The instructions of the summarization request.
+const summarizePrompt = "Summarize the following conversation between a user and an assistant in a few sentences. Keep the names, facts, decisions and open questions needed to continue it."
+
+// The prefix of the system message replacing the summarized messages.
+const summaryPrefix = "Previous conversation summary: "
+
+// shouldSummarize returns true if the conversation must be summarized before sending it with the given engine.
+func (c *Client) shouldSummarize(conversation Conversation, engine string, askOpts ...AskOpts) bool {
+	if c.compression != CompressionSummarize || (len(askOpts) > 0 && askOpts[0].NoCompression) {
+		return false
+	}
+	maxRuns := c.compressRuns
+	if maxRuns == 0 {
+		maxRuns = DEFAULT_COMPRESSION_MAX_RUNS
+	}
+	if conversation.Summaries >= maxRuns {
+		return false
+	}
+	threshold := c.compressAt
+	if threshold == 0 {
+		threshold = DEFAULT_COMPRESSION_THRESHOLD
+	}
+	return float64(countTokens(engine, conversation.Messages)) >= threshold*float64(getEngineTokenLimit(engine))
+}
+
+// summarizeConversation asks the model to summarize the messages between the system message and the latest prompt,
+// and returns the conversation with these messages replaced by a single system message holding the summary.
+// The summarization uses the same engine and credentials as the conversation.
+func (c *Client) summarizeConversation(ctx context.Context, conversation Conversation, engine string) (Conversation, error) {
+	messages := conversation.Messages
+	if len(messages) < 4 {
+		return conversation, nil // nothing worth summarizing between the system message and the prompt
+	}
+	oldest, prompt := messages[1:len(messages)-1], messages[len(messages)-1]
+
+	var transcript strings.Builder
+	for _, m := range oldest {
+		speaker := m.Role
+		if m.Name != "" {
+			speaker += " (" + m.Name + ")"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", speaker, m.Content)
+	}
+	response, err := c.askOpenAI(ctx, engine, []Message{
+		{Role: "system", Content: summarizePrompt},
+		{Role: "user", Content: transcript.String()},
+	}, nil)
+	if err != nil {
+		return conversation, fmt.Errorf("failed to summarize the conversation: %w", err)
+	}
+	c.logger.Debugf("Summarized %d messages, %d tokens used", len(oldest), response.Usage.TotalTokens)
+
+	conversation.Messages = []Message{
+		messages[0],
+		{Role: "system", Content: summaryPrefix + response.GetResponse()},
+		prompt,
+	}
+	conversation.Summaries++
+	return conversation, nil
+}
@@ -0,0 +1,82 @@
+package chatgpt
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// RegisterTemplate registers a prompt template under the given name, using the text/template syntax.
+// Registered templates can include each other with {{template "name" .}}, and a template registered again replaces the previous one.
+// With Config.StrictTemplates, rendering fails on variables missing from vars instead of printing "<no value>".
+func (c *Client) RegisterTemplate(name, text string) error {
+	c.templatesMu.Lock()
+	defer c.templatesMu.Unlock()
+	if c.templates == nil {
+		c.templates = template.New("")
+		if c.strictTemplates {
+			c.templates.Option("missingkey=error")
+		}
+	}
+	if _, err := c.templates.New(name).Parse(text); err != nil {
+		return fmt.Errorf("invalid template %s: %w", name, err)
+	}
+	return nil
+}
+
+// RenderTemplate renders the template registered under the given name with vars.
+func (c *Client) RenderTemplate(name string, vars map[string]interface{}) (string, error) {
+	c.templatesMu.RLock()
+	defer c.templatesMu.RUnlock()
+	if c.templates == nil || c.templates.Lookup(name) == nil {
+		return "", fmt.Errorf("template %s is not registered", name)
+	}
+	var text strings.Builder
+	if err := c.templates.ExecuteTemplate(&text, name, vars); err != nil {
+		return "", fmt.Errorf("failed to render template %s: %w", name, err)
+	}
+	return text.String(), nil
+}
+
+// AskTemplate renders the template registered under the given name with vars and sends it like Ask.
+// To carry variables in the system message, create the conversation with ConversationOpts.SystemTemplate.
+func (c *Client) AskTemplate(ctx context.Context, name string, vars map[string]interface{}, opts ...AskOpts) (*ChatResponse, error) {
+	prompt, err := c.RenderTemplate(name, vars)
+	if err != nil {
+		return nil, err
+	}
+	return c.Ask(ctx, prompt, opts...)
+}
+
+// placeholder matches the {{var}} placeholders expanded by ExpandTemplate.
+var placeholder = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+// ExpandTemplate replaces the {{var}} placeholders of tmpl with the values in vars, without registering a template.
+// With Config.StrictTemplates, a placeholder missing from vars is an error, otherwise it is replaced with an empty string.
+func (c *Client) ExpandTemplate(tmpl string, vars map[string]string) (string, error) {
+	var missing []string
+	text := placeholder.ReplaceAllStringFunc(tmpl, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		value, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 && c.strictTemplates {
+		return "", fmt.Errorf("missing template variables: %s", strings.Join(missing, ", "))
+	}
+	return text, nil
+}
+
+// AskTemplateText expands the {{var}} placeholders of tmpl with ExpandTemplate and sends the result with Ask.
+// Use RegisterTemplate and AskTemplate for templates reused across calls or needing the full text/template syntax.
+func (c *Client) AskTemplateText(ctx context.Context, tmpl string, vars map[string]string, opts ...AskOpts) (*ChatResponse, error) {
+	prompt, err := c.ExpandTemplate(tmpl, vars)
+	if err != nil {
+		return nil, err
+	}
+	return c.Ask(ctx, prompt, opts...)
+}
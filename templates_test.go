@@ -0,0 +1,87 @@
+package chatgpt_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amarnathcjd/chatgpt"
+	"github.com/amarnathcjd/chatgpt/chatgpttest"
+)
+
+func TestAskTemplate(t *testing.T) {
+	server := chatgpttest.NewServer(chatgpttest.Response{Message: "Bonjour"})
+	defer server.Close()
+	client := startApiKeyClient(t, server, chatgpt.Config{})
+
+	if err := client.RegisterTemplate("broken", "{{.Name"); err == nil {
+		t.Error("invalid template registered")
+	}
+	if _, err := client.RenderTemplate("missing", nil); err == nil {
+		t.Error("rendered an unregistered template")
+	}
+	for name, text := range map[string]string{
+		"persona":   "You are a {{.Role}}.",
+		"greeting":  `{{template "persona" .}} Translate "{{.Text}}" to {{.Lang}}.`,
+		"translate": `Translate "{{.Text}}".`,
+	} {
+		if err := client.RegisterTemplate(name, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Prompts are plain text, values aren't HTML escaped
+	id, err := client.NewConversation(chatgpt.ConversationOpts{SystemTemplate: "persona", Vars: map[string]interface{}{"Role": "translator & <tutor>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]interface{}{"Role": "translator", "Text": "Hello <b>&</b>", "Lang": "French"}
+	if _, err := client.AskTemplate(context.Background(), "greeting", vars, chatgpt.AskOpts{ConversationID: id}); err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{
+		{"system", "You are a translator & <tutor>."},
+		{"user", `You are a translator. Translate "Hello <b>&</b>" to French.`},
+	}
+	if got := server.Requests()[0].Messages(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+
+	// Missing variables are printed as <no value> unless StrictTemplates is set
+	if text, err := client.RenderTemplate("translate", nil); err != nil || text != `Translate "<no value>".` {
+		t.Errorf("got %q (%v)", text, err)
+	}
+	strict := startApiKeyClient(t, server, chatgpt.Config{StrictTemplates: true})
+	if err := strict.RegisterTemplate("translate", `Translate "{{.Text}}".`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strict.AskTemplate(context.Background(), "translate", map[string]interface{}{}); err == nil {
+		t.Error("a missing variable is accepted with StrictTemplates")
+	}
+	if len(server.Requests()) != 1 {
+		t.Error("a template failing to render was sent")
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	client := startClient(t, chatgpt.Config{ApiKey: "sk-test"})
+	strict := startClient(t, chatgpt.Config{ApiKey: "sk-test", StrictTemplates: true})
+
+	for _, test := range []struct {
+		tmpl, want string
+		vars       map[string]string
+		strictErr  bool
+	}{
+		{"Hello {{ name }}, {{name}}!", "Hello Bob, Bob!", map[string]string{"name": "Bob"}, false},
+		// Values are inserted as is, placeholders in them aren't expanded again
+		{"Say {{text}}", "Say {{name}} & <b>", map[string]string{"text": "{{name}} & <b>", "name": "Bob"}, false},
+		{"Hello {{name}}!", "Hello !", nil, true},
+		{"Not a {{ placeholder!}}", "Not a {{ placeholder!}}", nil, false},
+	} {
+		if got, err := client.ExpandTemplate(test.tmpl, test.vars); err != nil || got != test.want {
+			t.Errorf("ExpandTemplate(%q) = %q (%v), want %q", test.tmpl, got, err, test.want)
+		}
+		if _, err := strict.ExpandTemplate(test.tmpl, test.vars); (err != nil) != test.strictErr {
+			t.Errorf("strict ExpandTemplate(%q) error %v", test.tmpl, err)
+		}
+	}
+}
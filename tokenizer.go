@@ -0,0 +1,152 @@
+package chatgpt
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// The encoding used for engines unknown to the tokenizer, the one of the gpt-3.5-turbo and gpt-4 families.
+const DEFAULT_ENCODING = "cl100k_base"
+
+var (
+	// encodings caches the tokenizer of each engine once it is loaded.
+	encodings   = make(map[string]*tiktoken.Tiktoken)
+	encodingsMu sync.Mutex
+)
+
+func init() {
+	// Load the BPE ranks from the files embedded in the binary, instead of downloading them on first use
+	// without the proxy and transport of the client.
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// encodingForEngine returns the tokenizer of the given engine, falling back to DEFAULT_ENCODING.
+// A tokenizer that failed to load is tried again on the next call.
+func encodingForEngine(engine string) (*tiktoken.Tiktoken, error) {
+	encodingsMu.Lock()
+	encoding, ok := encodings[engine]
+	encodingsMu.Unlock()
+	if ok {
+		return encoding, nil
+	}
+
+	// Load outside the lock, so that counting the tokens of other engines doesn't wait for it
+	encoding, err := tiktoken.EncodingForModel(engine)
+	if err != nil {
+		if encoding, err = tiktoken.GetEncoding(DEFAULT_ENCODING); err != nil {
+			return nil, fmt.Errorf("failed to load the tokenizer: %w", err)
+		}
+	}
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	encodings[engine] = encoding
+	return encoding, nil
+}
+
+// BiasForWords returns the logit bias applying bias to the tokens of each word, to be used as Config.LogitBias or AskOpts.LogitBias.
+// Each word is encoded with the tokenizer of the client's engine both as is and with a leading space, as it appears mid-sentence.
+// A word spanning several tokens biases all of them, which also affects other words sharing these tokens.
+// Use -100 to ban the words, or 100 to only allow them.
+func (c *Client) BiasForWords(words []string, bias float64) (map[string]float64, error) {
+	if bias < -100 || bias > 100 {
+		return nil, fmt.Errorf("logit bias must be between -100 and 100, got %v", bias)
+	}
+	encoding, err := encodingForEngine(c.GetEngine())
+	if err != nil {
+		return nil, err
+	}
+	logitBias := make(map[string]float64)
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		for _, variant := range []string{word, " " + word} {
+			for _, token := range encoding.EncodeOrdinary(variant) {
+				logitBias[strconv.Itoa(token)] = bias
+			}
+		}
+	}
+	return logitBias, nil
+}
+
+// The number of tokens added by the API for each message, to prime the reply, and for each message with a name.
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+	tokensPerName    = 1
+)
+
+// countTokens returns the number of prompt tokens of messages for the given engine.
+// If the tokenizer can't be loaded, e.g. offline, it falls back to an estimate of 4 bytes per token.
+func countTokens(engine string, messages []Message) int {
+	encode := func(text string) int { return len(text) / 4 }
+	if encoding, err := encodingForEngine(engine); err == nil {
+		encode = func(text string) int { return len(encoding.EncodeOrdinary(text)) }
+	}
+	count := tokensPerReply
+	for _, m := range messages {
+		count += tokensPerMessage + encode(m.Role) + encode(m.Content)
+		if m.Name != "" {
+			count += encode(m.Name) + tokensPerName
+		}
+	}
+	return count
+}
+
+// The default number of tokens reserved for the response by RemainingTokens, see Config.ResponseReserve.
+const DEFAULT_RESPONSE_RESERVE = 500
+
+// TokenLimit returns the context window in tokens of the client's engine.
+func (c *Client) TokenLimit() int {
+	return getEngineTokenLimit(c.GetEngine())
+}
+
+// ConversationTokens returns the number of prompt tokens of a conversation, counted with the tokenizer of its engine.
+// It is safe to call while other requests run.
+func (c *Client) ConversationTokens(id string) (int, error) {
+	messages, engine, err := c.conversationSnapshot(id)
+	if err != nil {
+		return 0, err
+	}
+	return countTokens(engine, messages), nil
+}
+
+// RemainingTokens returns the number of tokens left in the context window of a conversation's engine,
+// after its messages and the tokens reserved for the response, see Config.ResponseReserve. Once the next prompt
+// doesn't fit in them, asking it truncates the conversation first, see Config.CompressionStrategy (API key mode only).
+func (c *Client) RemainingTokens(id string) (int, error) {
+	messages, engine, err := c.conversationSnapshot(id)
+	if err != nil {
+		return 0, err
+	}
+	return c.tokenBudget(engine) - countTokens(engine, messages), nil
+}
+
+// tokenBudget returns the number of prompt tokens that fit in the context window of the given engine,
+// once the tokens reserved for the response are set aside.
+func (c *Client) tokenBudget(engine string) int {
+	reserve := c.reserve
+	if reserve == 0 {
+		reserve = DEFAULT_RESPONSE_RESERVE
+	}
+	return getEngineTokenLimit(engine) - reserve
+}
+
+// conversationSnapshot returns a copy of the messages of a conversation and its engine.
+func (c *Client) conversationSnapshot(id string) ([]Message, string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	conv, ok := c.conversations[id]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrConversationNotFound, id)
+	}
+	engine := c.GetEngine()
+	if conv.Engine != "" {
+		engine = conv.Engine
+	}
+	return conv.clone().Messages, engine, nil
+}
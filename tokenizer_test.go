@@ -0,0 +1,83 @@
+package chatgpt
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestEncodingForEngine(t *testing.T) {
+	// The tokenizers of known and unknown engines load concurrently from the embedded BPE files
+	var wg sync.WaitGroup
+	for _, engine := range []string{EngineGPT4, EngineGPT35Turbo, "my-local-model"} {
+		wg.Add(1)
+		go func(engine string) {
+			defer wg.Done()
+			encoding, err := encodingForEngine(engine)
+			if err != nil {
+				t.Errorf("%s: %v", engine, err)
+				return
+			}
+			if tokens := encoding.EncodeOrdinary("hello world"); len(tokens) != 2 {
+				t.Errorf("%s: got %d tokens for \"hello world\", want 2", engine, len(tokens))
+			}
+		}(engine)
+	}
+	wg.Wait()
+}
+
+func TestBiasForWords(t *testing.T) {
+	client := NewClient(&Config{Engine: EngineGPT4})
+	bias, err := client.BiasForWords([]string{"hello", ""}, -100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "hello" and " hello" are single tokens
+	if len(bias) != 2 {
+		t.Errorf("got %d biased tokens, want 2: %v", len(bias), bias)
+	}
+	for token, value := range bias {
+		if value != -100 {
+			t.Errorf("got bias %v for token %s", value, token)
+		}
+	}
+	if _, err := client.BiasForWords([]string{"hello"}, 101); err == nil {
+		t.Error("expected an error for a bias out of range")
+	}
+}
+
+func TestConversationTokens(t *testing.T) {
+	client := NewClient(&Config{Engine: EngineGPT4, ResponseReserve: 1000})
+	client.SetConversation("c", Conversation{Messages: []Message{
+		{Role: "system", Content: "hello world"},
+		{Role: "user", Content: "hi", Name: "bob"},
+	}})
+
+	// 3 to prime the reply, then 3 per message with its role and content, and the name with its overhead
+	tokens, err := client.ConversationTokens("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 3 + (3 + 1 + 2) + (3 + 1 + 1 + 1 + 1); tokens != want {
+		t.Errorf("got %d tokens, want %d", tokens, want)
+	}
+	remaining, err := client.RemainingTokens("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 8000 - 1000 - tokens; remaining != want {
+		t.Errorf("got %d remaining tokens, want %d", remaining, want)
+	}
+
+	// The engine pinned on the conversation sets the limit
+	if err := client.SetConversationEngine("c", EngineGPT4o); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := client.RemainingTokens("c"); remaining != 128000-1000-tokens {
+		t.Errorf("got %d remaining tokens with gpt-4o", remaining)
+	}
+
+	if _, err := client.RemainingTokens("missing"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("got error %v for a missing conversation", err)
+	}
+}
@@ -0,0 +1,87 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The file where access tokens are cached by default.
+const DEFAULT_TOKEN_CACHE_FILE = "gpt-cache.json"
+
+// TokenStore is where access tokens are cached between runs, keyed by session name.
+// Implement it to share tokens between instances, e.g. in Redis or a database, and set it with Config.TokenStore.
+type TokenStore interface {
+	// Load returns the access token saved for the session and its expiry, or an empty token if there is none.
+	Load(session string) (token string, expires time.Time, err error)
+	// Save saves the access token of the session and its expiry.
+	Save(session string, token string, expires time.Time) error
+}
+
+// defaultTokenStore is the token store used when none is configured.
+var defaultTokenStore = NewFileTokenStore(DEFAULT_TOKEN_CACHE_FILE)
+
+// FileTokenStore is a TokenStore saving the tokens of all sessions in a JSON file.
+type FileTokenStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileTokenStore returns a TokenStore saving tokens in the JSON file at path.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{path: path}
+}
+
+type authCache struct {
+	AccessToken string    `json:"access_token,omitempty"`
+	Expires     time.Time `json:"expires,omitempty"`
+}
+
+// Load returns the access token saved for the session, a missing or unreadable file is treated as an empty store.
+func (s *FileTokenStore) Load(session string) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cache := s.read()[session]
+	return cache.AccessToken, cache.Expires, nil
+}
+
+// Save saves the access token of the session, keeping the ones of the other sessions.
+// The file is written to a temporary file first and renamed over the previous one,
+// so an interrupted save never leaves a partially written file.
+func (s *FileTokenStore) Save(session string, token string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.read()
+	data[session] = authCache{
+		AccessToken: token,
+		Expires:     expires,
+	}
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // no-op once renamed
+	if err := json.NewEncoder(file).Encode(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
+
+// read decodes the file, returning an empty map if it is missing or invalid.
+func (s *FileTokenStore) read() map[string]authCache {
+	var data map[string]authCache
+	if file, err := os.Open(s.path); err == nil {
+		defer file.Close()
+		json.NewDecoder(file).Decode(&data)
+	}
+	if data == nil {
+		data = make(map[string]authCache)
+	}
+	return data
+}
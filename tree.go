@@ -0,0 +1,184 @@
+package chatgpt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConversationTree is the tree of messages of an access token mode conversation, as stored by the backend.
+// Each regenerated answer or edited question starts a new branch from the same parent node.
+// Trees are never modified in place, the client replaces them when a conversation is continued.
+type ConversationTree struct {
+	ID          string               `json:"id,omitempty"`           // The ID of the conversation.
+	Title       string               `json:"title,omitempty"`        // The title of the conversation, as shown in the web UI.
+	CurrentNode string               `json:"current_node,omitempty"` // The ID of the leaf the conversation continues from by default.
+	Nodes       map[string]*TreeNode `json:"nodes,omitempty"`        // The nodes of the tree by message ID.
+}
+
+// TreeNode is a node of a ConversationTree.
+type TreeNode struct {
+	ID       string   `json:"id"`                 // The ID of the message, to be used as AskOpts.ParentID.
+	Parent   string   `json:"parent,omitempty"`   // The ID of the parent node, empty for the root.
+	Children []string `json:"children,omitempty"` // The IDs of the child nodes, one per branch.
+	Message  *Message `json:"message,omitempty"`  // The message of the node, nil for the root.
+}
+
+// Root returns the root node of the tree, nil if the tree is empty.
+func (t *ConversationTree) Root() *TreeNode {
+	for _, node := range t.Nodes {
+		if node.Parent == "" || t.Nodes[node.Parent] == nil {
+			return node
+		}
+	}
+	return nil
+}
+
+// Children returns the child nodes of the node with the given ID, in the order they were added.
+func (t *ConversationTree) Children(id string) []*TreeNode {
+	node := t.Nodes[id]
+	if node == nil {
+		return nil
+	}
+	children := make([]*TreeNode, 0, len(node.Children))
+	for _, child := range node.Children {
+		if childNode := t.Nodes[child]; childNode != nil {
+			children = append(children, childNode)
+		}
+	}
+	return children
+}
+
+// Path returns the nodes from the root to the node with the given ID, nil if there is no such node.
+func (t *ConversationTree) Path(id string) []*TreeNode {
+	var path []*TreeNode
+	for node := t.Nodes[id]; node != nil; node = t.Nodes[node.Parent] {
+		path = append(path, node)
+		if len(path) > len(t.Nodes) {
+			return nil // a cycle, the tree is malformed
+		}
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Messages returns the messages from the root to the node with the given ID, the conversation as seen from this leaf.
+func (t *ConversationTree) Messages(id string) []Message {
+	var messages []Message
+	for _, node := range t.Path(id) {
+		if node.Message != nil && node.Message.Content != "" {
+			messages = append(messages, *node.Message)
+		}
+	}
+	return messages
+}
+
+// Leaves returns the IDs of the nodes without children, sorted, one per branch.
+func (t *ConversationTree) Leaves() []string {
+	var leaves []string
+	for id, node := range t.Nodes {
+		if len(node.Children) == 0 {
+			leaves = append(leaves, id)
+		}
+	}
+	sort.Strings(leaves)
+	return leaves
+}
+
+// Branches returns the path from the root to each leaf, e.g. one per regenerated answer, in the order of Leaves.
+func (t *ConversationTree) Branches() [][]*TreeNode {
+	leaves := t.Leaves()
+	branches := make([][]*TreeNode, 0, len(leaves))
+	for _, leaf := range leaves {
+		branches = append(branches, t.Path(leaf))
+	}
+	return branches
+}
+
+// withTurn returns a copy of the tree with a question and its answer added under the node parentID,
+// the answer becoming the current node. Nodes already in the tree are left as they are.
+func (t *ConversationTree) withTurn(parentID string, question, answer TreeNode) *ConversationTree {
+	tree := *t
+	tree.Nodes = make(map[string]*TreeNode, len(t.Nodes)+2)
+	for id, node := range t.Nodes {
+		tree.Nodes[id] = node
+	}
+	for _, node := range []TreeNode{question, answer} {
+		if node.ID == "" || tree.Nodes[node.ID] != nil {
+			continue
+		}
+		node := node
+		node.Parent = parentID
+		if parent := tree.Nodes[parentID]; parent != nil {
+			updated := *parent
+			updated.Children = append(append([]string(nil), parent.Children...), node.ID)
+			tree.Nodes[parentID] = &updated
+		}
+		tree.Nodes[node.ID] = &node
+		parentID = node.ID
+	}
+	if answer.ID != "" {
+		tree.CurrentNode = answer.ID
+	}
+	return &tree
+}
+
+// remoteConversation is a conversation as returned by the backend conversation endpoint.
+type remoteConversation struct {
+	Title       string `json:"title"`
+	CurrentNode string `json:"current_node"`
+	Mapping     map[string]struct {
+		ID       string   `json:"id"`
+		Parent   string   `json:"parent"`
+		Children []string `json:"children"`
+		Message  *struct {
+			Author struct {
+				Role string `json:"role"`
+			} `json:"author"`
+			Content map[string]interface{} `json:"content"`
+		} `json:"message"`
+	} `json:"mapping"`
+}
+
+// GetRemoteConversation fetches the tree of messages of a conversation from the backend, only supported in access token mode.
+// The tree is saved in the local conversation, see Conversation.Tree, and the conversation continues from its current node.
+// Pass the ID of another node as AskOpts.ParentID to continue a different branch.
+func (c *Client) GetRemoteConversation(ctx context.Context, id string) (*ConversationTree, error) {
+	if err := c.checkAccessTokenMode("remote conversations"); err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, fmt.Errorf("no conversation ID given")
+	}
+	var remote remoteConversation
+	if err := c.backendRequest(ctx, "GET", "conversation/"+id, nil, &remote); err != nil {
+		return nil, err
+	}
+
+	tree := &ConversationTree{ID: id, Title: remote.Title, CurrentNode: remote.CurrentNode, Nodes: make(map[string]*TreeNode, len(remote.Mapping))}
+	for nodeID, node := range remote.Mapping {
+		treeNode := &TreeNode{ID: nodeID, Parent: node.Parent, Children: node.Children}
+		if node.Message != nil {
+			text, _, _ := messageContent(node.Message.Content)
+			treeNode.Message = &Message{Role: node.Message.Author.Role, Content: c.redactResponse(strings.TrimSpace(text))}
+		}
+		tree.Nodes[nodeID] = treeNode
+	}
+
+	// Keep the tree in the local conversation, continuing from the backend's current node
+	c.cachedConversation(id)
+	c.mu.Lock()
+	conversation, exists := c.conversations[id]
+	conversation.Tree = tree
+	conversation.ParentID = tree.CurrentNode
+	c.conversations[id] = conversation
+	c.mu.Unlock()
+	if !exists {
+		c.conversationCreated(id, conversation)
+	}
+	c.saveConversation(id, conversation)
+	return tree, nil
+}
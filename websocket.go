@@ -0,0 +1,273 @@
+package chatgpt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"golang.org/x/net/proxy"
+)
+
+// openResponseBody returns the body to parse for a successful conversation response.
+// If the backend answered with a {"wss_url": ...} payload instead of an SSE stream, the WebSocket is opened
+// and its decoded frames are returned as a data:-style stream, otherwise the response body is returned as is.
+func (c *Client) openResponseBody(ctx context.Context, resp *http.Response) (io.ReadCloser, error) {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return resp.Body, nil
+	}
+
+	// Read the JSON payload and restore it if it isn't a wss_url response
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("system error: %w", err)
+	}
+	var payload struct {
+		WssUrl string `json:"wss_url"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil || payload.WssUrl == "" {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	c.logger.Debug("Switching to WebSocket stream")
+	return c.openWebSocket(ctx, payload.WssUrl)
+}
+
+// openWebSocket connects to the given wss_url and returns a reader yielding the decoded "body" of each frame.
+// The connection is closed when the reader is closed or the context is cancelled.
+func (c *Client) openWebSocket(ctx context.Context, wssUrl string) (io.ReadCloser, error) {
+	dialer, err := c.webSocketDialer(wssUrl)
+	if err != nil {
+		return nil, fmt.Errorf("websocket connection error: %w", err)
+	}
+	conn, br, _, err := dialer.Dial(ctx, wssUrl)
+	if err != nil {
+		return nil, fmt.Errorf("websocket connection error: %w", err)
+	}
+	if br != nil {
+		// The frames received along with the handshake response are buffered in br
+		conn = &bufferedConn{Conn: conn, reader: br}
+	}
+
+	reader, writer := io.Pipe()
+	body := &webSocketBody{PipeReader: reader, conn: conn, done: make(chan struct{})}
+
+	// Close the connection on context cancellation
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-body.done:
+		}
+	}()
+
+	// Decode the frames and feed their bodies to the reader
+	go func() {
+		defer conn.Close()
+		for {
+			frame, err := wsutil.ReadServerText(conn)
+			if err != nil {
+				var closed wsutil.ClosedError
+				if errors.As(err, &closed) && closed.Code == ws.StatusNormalClosure {
+					writer.Close()
+				} else if ctx.Err() != nil {
+					writer.CloseWithError(ctx.Err())
+				} else {
+					writer.CloseWithError(fmt.Errorf("websocket stream error: %w", err))
+				}
+				return
+			}
+
+			var message struct {
+				Body string `json:"body"`
+			}
+			if err := json.Unmarshal(frame, &message); err != nil || message.Body == "" {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(message.Body)
+			if err != nil {
+				c.logger.Debug("Skipping malformed websocket frame")
+				continue
+			}
+			if _, err := writer.Write(decoded); err != nil {
+				return // the reader was closed
+			}
+		}
+	}()
+	return body, nil
+}
+
+// webSocketDialer returns the dialer of the WebSocket at wssUrl, which connects like the HTTP requests of the client:
+// through its proxy, with the dialer and TLS config of its transport, and sending its browser headers.
+func (c *Client) webSocketDialer(wssUrl string) (ws.Dialer, error) {
+	target, err := url.Parse(wssUrl)
+	if err != nil {
+		return ws.Dialer{}, err
+	}
+	c.transport.mu.RLock()
+	transport, ok := c.transport.rt.(*http.Transport)
+	c.transport.mu.RUnlock()
+	if !ok {
+		transport = defaultTransport
+	}
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	header := make(http.Header)
+	c.setBrowserHeaders(&http.Request{Header: header})
+	dialer := ws.Dialer{Header: ws.HandshakeHeaderHTTP(header), NetDial: dial}
+	if transport.TLSClientConfig != nil {
+		dialer.TLSConfig = transport.TLSClientConfig.Clone()
+	}
+	if transport.Proxy == nil {
+		return dialer, nil
+	}
+
+	// The proxy is the one of the equivalent HTTP URL, so that the environment proxy rules apply
+	httpUrl := *target
+	httpUrl.Scheme = strings.Replace(target.Scheme, "ws", "http", 1)
+	proxyUrl, err := transport.Proxy(&http.Request{Method: http.MethodGet, URL: &httpUrl, Header: header})
+	if err != nil || proxyUrl == nil {
+		return dialer, err
+	}
+	switch proxyUrl.Scheme {
+	case "http", "https":
+		dialer.NetDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialConnect(ctx, transport, dial, proxyUrl, addr)
+		}
+	case "socks5", "socks5h":
+		socks, err := proxy.FromURL(proxyUrl, proxy.Direct)
+		if err != nil {
+			return ws.Dialer{}, fmt.Errorf("invalid SOCKS5 proxy: %w", err)
+		}
+		if contextDialer, ok := socks.(proxy.ContextDialer); ok {
+			dialer.NetDial = contextDialer.DialContext
+		} else {
+			dialer.NetDial = func(_ context.Context, network, addr string) (net.Conn, error) {
+				return socks.Dial(network, addr)
+			}
+		}
+	default:
+		return ws.Dialer{}, fmt.Errorf("unsupported proxy scheme: %s", proxyUrl.Scheme)
+	}
+	return dialer, nil
+}
+
+// dialConnect opens a tunnel to addr through an HTTP(S) proxy with a CONNECT request,
+// authenticated with the ProxyConnectHeader of the transport or the credentials of the proxy URL.
+func dialConnect(ctx context.Context, transport *http.Transport, dial func(context.Context, string, string) (net.Conn, error),
+	proxyUrl *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyUrl.Host
+	if proxyUrl.Port() == "" {
+		port := "80"
+		if proxyUrl.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyUrl.Hostname(), port)
+	}
+	conn, err := dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Abort the handshake with the proxy when the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if proxyUrl.Scheme == "https" {
+		config := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			config = transport.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = proxyUrl.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	header := transport.ProxyConnectHeader.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Proxy-Authorization") == "" && proxyUrl.User != nil {
+		password, _ := proxyUrl.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.Username() + ":" + password))
+		header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: header}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The server speaks only after the client, so the reader can't buffer more than the response
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy error: %s", resp.Status)
+	}
+	if ctx.Err() != nil {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were buffered by a reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads from the buffered bytes first, then from the connection.
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// webSocketBody is the reader returned by openWebSocket, closing it also closes the connection.
+type webSocketBody struct {
+	*io.PipeReader
+	conn net.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+// Close closes the reader and the underlying connection.
+func (b *webSocketBody) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+		b.conn.Close()
+	})
+	return b.PipeReader.Close()
+}
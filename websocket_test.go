@@ -0,0 +1,99 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// connectProxy is an HTTP proxy tunneling CONNECT requests, which records their targets and Proxy-Authorization headers.
+type connectProxy struct {
+	mu      sync.Mutex
+	targets []string
+	auth    []string
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	p.targets = append(p.targets, r.Host)
+	p.auth = append(p.auth, r.Header.Get("Proxy-Authorization"))
+	p.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+func TestOpenWebSocketThroughProxy(t *testing.T) {
+	userAgents := make(chan string, 1)
+	wsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		conn, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frame, _ := json.Marshal(map[string]string{"body": base64.StdEncoding.EncodeToString([]byte("data: hello\n\n"))})
+		wsutil.WriteServerText(conn, frame)
+		wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, ""))
+	}))
+	defer wsServer.Close()
+
+	connect := &connectProxy{}
+	proxyServer := httptest.NewServer(connect)
+	defer proxyServer.Close()
+	proxyUrl, _ := url.Parse(proxyServer.URL)
+	proxyUrl.User = url.UserPassword("user", "secret")
+
+	client := NewClient(&Config{ApiKey: "sk-test", Proxy: proxyUrl, UserAgent: "test-agent", LogLevel: LogLevelError})
+	defer client.Close()
+	wssUrl := strings.Replace(wsServer.URL, "http", "ws", 1)
+	body, err := client.openWebSocket(context.Background(), wssUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "data: hello\n\n" {
+		t.Errorf("got %q and error %v", data, err)
+	}
+
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	connect.mu.Lock()
+	defer connect.mu.Unlock()
+	if len(connect.targets) != 1 || connect.targets[0] != strings.TrimPrefix(wsServer.URL, "http://") || connect.auth[0] != wantAuth {
+		t.Errorf("got CONNECT requests to %q with %q", connect.targets, connect.auth)
+	}
+	if userAgent := <-userAgents; userAgent != "test-agent" {
+		t.Errorf("got User-Agent %q, want the client's", userAgent)
+	}
+}